@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/bryce/bashly/manual"
 	"github.com/jroimartin/gocui"
 )
 
@@ -36,6 +37,8 @@ type Config struct {
 	X1      int    `json:"x1"`
 	Y1      int    `json:"y1"`
 	TabSize int    `json:"tabSize"`
+
+	Style manual.Style `json:"style"` // looks of the manual pages in a manual box
 }
 
 // New creates the boxes for the given box configurations.
@@ -47,6 +50,9 @@ func New(cfgs []Config) (*Boxes, error) {
 		case "Script":
 			box = NewScript(&cfg)
 		case "Manual":
+			if err := cfg.Style.Validate(); err != nil {
+				return nil, fmt.Errorf("box number %d has invalid style: %v", i+1, err)
+			}
 			box = NewManual(&cfg)
 		case "Options":
 			box = NewOptions(&cfg)
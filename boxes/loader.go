@@ -0,0 +1,134 @@
+package boxes
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bryce/bashly/cmds"
+	"github.com/bryce/bashly/manual"
+	"github.com/jroimartin/gocui"
+)
+
+// loader loads the page shown in a box in the background, so that slow
+// providers don't freeze the editor.
+type loader struct {
+	cancel context.CancelFunc // cancels the load that is running, if any
+}
+
+// load shows a placeholder in the view while get loads the page for the
+// command in the background, then calls the function returned by get to show
+// the page in the cleared view. A load that is still running is cancelled,
+// as its page is no longer wanted.
+func (l *loader) load(gui *gocui.Gui, view *gocui.View, command string,
+	get func(ctx context.Context) func(view *gocui.View)) {
+	view.Clear()
+	view.SetOrigin(0, 0)
+	view.SetCursor(0, 0)
+	view.Title = view.Name()
+	view.Write([]byte("loading " + command + "…"))
+
+	l.start(gui, view.Name(), get, nil)
+}
+
+// reload loads the page again, such as for a new width, leaving the old page
+// in the view until the new one is shown. The new page is scrolled to about
+// the same place in it as the old one, and the cursor is kept in the same
+// place in the view.
+func (l *loader) reload(gui *gocui.Gui, view *gocui.View,
+	get func(ctx context.Context) func(view *gocui.View)) {
+	ox, oy := view.Origin()
+	cx, cy := view.Cursor()
+	lines := len(view.BufferLines())
+
+	l.start(gui, view.Name(), get, func(view *gocui.View) {
+		// Pages wrapped to a new width have more or fewer lines
+		if newLines := len(view.BufferLines()); lines > 0 && newLines != lines {
+			oy = oy * newLines / lines
+		}
+		_, maxY := view.Size()
+		if newLines := len(view.BufferLines()); oy > newLines-maxY {
+			oy = newLines - maxY
+		}
+		if oy < 0 {
+			oy = 0
+		}
+		view.SetOrigin(ox, oy)
+
+		maxX, _ := view.Size()
+		if cx >= maxX {
+			cx = maxX - 1
+		}
+		if cy >= maxY {
+			cy = maxY - 1
+		}
+		if cx >= 0 && cy >= 0 {
+			view.SetCursor(cx, cy)
+		}
+	})
+}
+
+// start runs get in the background, then shows its page in the cleared view
+// and calls restore, which can be nil, to restore the place in the page.
+func (l *loader) start(gui *gocui.Gui, name string,
+	get func(ctx context.Context) func(view *gocui.View), restore func(view *gocui.View)) {
+	l.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+
+	go func() {
+		show := get(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		gui.Update(func(gui *gocui.Gui) error {
+			// A newer load may have started since
+			if ctx.Err() != nil {
+				return nil
+			}
+			cancel()
+			l.cancel = nil
+
+			view, viewErr := gui.View(name)
+			if viewErr != nil {
+				return viewErr
+			}
+			view.Clear()
+			view.Title = name
+			show(view)
+			if restore != nil {
+				restore(view)
+			}
+
+			return nil
+		})
+	}()
+}
+
+// stop cancels the load that is running, if any.
+func (l *loader) stop() {
+	if l.cancel != nil {
+		l.cancel()
+		l.cancel = nil
+	}
+}
+
+// noCommandTitle returns the title of a box when there is no command, which
+// tells if the cursor is in a heredoc rather than on a command.
+func noCommandTitle(name string, err error) string {
+	var heredoc *cmds.HeredocError
+	if errors.As(err, &heredoc) {
+		return name + " — " + heredoc.Error()
+	}
+
+	return name
+}
+
+// providerTitle returns the title of a box showing a page from a provider.
+func providerTitle(name string, provider manual.Provider) string {
+	if provider == nil {
+		return name
+	}
+
+	return name + " — " + provider.Name()
+}
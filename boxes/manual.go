@@ -1,10 +1,12 @@
 package boxes
 
 import (
+	"context"
 	"errors"
 
 	"github.com/bryce/bashly/boxes/util"
 	"github.com/bryce/bashly/boxes/views"
+	"github.com/bryce/bashly/cmds"
 	"github.com/bryce/bashly/manual"
 	"github.com/jroimartin/gocui"
 )
@@ -12,12 +14,18 @@ import (
 // Manual is a type of box that holds the manual pages
 // associated with a script.
 type Manual struct {
-	name    string
-	refName string
-	unit    util.Coordinates
-	script  *Script
-	command string
-	subView views.View // change name
+	name     string
+	refName  string
+	unit     util.Coordinates
+	script   *Script
+	command  string
+	cmd      *cmds.Command
+	width    int      // width the manual page is formatted for
+	section  string   // section of the manual page shown, "" if it isn't one
+	sections []string // sections that have a manual page for the command
+	style    manual.Style
+	loader   loader
+	subView  views.View // change name
 }
 
 // NewManual creates a new manual page box.
@@ -29,6 +37,7 @@ func NewManual(cfg *Config) *Manual {
 	box.unit.Y0 = cfg.Y0
 	box.unit.X1 = cfg.X1
 	box.unit.Y1 = cfg.Y1
+	box.style = cfg.Style
 
 	return box
 }
@@ -55,6 +64,9 @@ func (box *Manual) Setup(gui *gocui.Gui, boxs *Boxes) error {
 	if err := gui.SetKeybinding(box.Name(), gocui.MouseWheelUp, gocui.ModNone, util.ScrollUp); err != nil {
 		return err
 	}
+	if err := gui.SetKeybinding(box.Name(), gocui.KeyCtrlN, gocui.ModNone, nextSection(box)); err != nil {
+		return err
+	}
 
 	return gui.SetKeybinding(box.Name(), gocui.KeyCtrlF, gocui.ModNone, search(box))
 }
@@ -88,7 +100,8 @@ func (box *Manual) SetViews(gui *gocui.Gui, active bool) error {
 	return nil
 }
 
-// Update updates the manual pages if the script comands change.
+// Update updates the manual pages if the script comands change or the box is
+// resized.
 func (box *Manual) Update(gui *gocui.Gui, active bool) error {
 	view, err := gui.View(box.Name())
 	if err != nil {
@@ -96,28 +109,117 @@ func (box *Manual) Update(gui *gocui.Gui, active bool) error {
 	}
 
 	cmd, err := box.script.Command()
-	if err != nil {
+	if err != nil || cmd.Name == "" {
+		box.loader.stop()
 		view.Clear()
+		view.Title = noCommandTitle(box.Name(), err)
 		box.command = ""
+		box.cmd = nil
 		return nil
 	}
 
-	if cmd.Name == box.command {
+	// A page is formatted for the width of the view, so it's formatted again
+	// if the view is resized
+	if cmd.FullName() == box.command {
+		if width, _ := view.Size(); width != box.width && box.cmd != nil {
+			shown := *box.cmd
+			if box.section != "" {
+				shown.Section = box.section
+			}
+			box.loader.reload(gui, view, box.get(view, &shown))
+		}
 		return nil
 	}
 
-	view.Clear()
-	view.SetOrigin(0, 0)
-	view.SetCursor(0, 0)
+	box.command = cmd.FullName()
+	box.cmd = cmd
+	box.section, box.sections = "", nil
+	box.load(gui, view, cmd)
+
+	return nil
+}
 
-	box.command = cmd.Name
+// notFoundStyle is the look of the message for a command that isn't found.
+const notFoundStyle = "\x1b[0;31;1m"
+
+// load loads the manual page for the command in the background, along with
+// the sections that have a page for it.
+func (box *Manual) load(gui *gocui.Gui, view *gocui.View, cmd *cmds.Command) {
+	box.loader.load(gui, view, cmd.FullName(), box.get(view, cmd))
+}
+
+// get returns a function that gets the manual page for the command formatted
+// for the width of the view. The title tells what runs for the command, such
+// as the program found in PATH or a function of the script.
+func (box *Manual) get(view *gocui.View,
+	cmd *cmds.Command) func(ctx context.Context) func(view *gocui.View) {
 	maxX, _ := view.Size()
-	page, err := manual.Get(cmd, maxX)
-	if err == nil {
-		view.Write(page)
+	box.width = maxX
+	style := box.style
+	// The parse of the script is only used on the GUI goroutine
+	defs := box.script.script.Definitions()
+	dir := box.script.dir
+
+	return func(ctx context.Context) func(view *gocui.View) {
+		resolution := manual.Resolve(cmd, defs, dir)
+
+		page, provider, err := manual.Get(ctx, cmd, maxX)
+		page = manual.Render(page, style)
+		title, section := providerTitle(box.Name()+" — "+resolution.String(), provider), ""
+		if _, ok := provider.(manual.Man); ok {
+			if name, pageSection, whichErr := (manual.Man{}).Which(ctx, cmd); whichErr == nil {
+				title = box.Name() + " — " + resolution.String() + " — " + name + "(" + pageSection + ")"
+				section = pageSection
+			}
+		}
+		if resolution.Type == manual.NotFound && !resolution.NoDir && err != nil {
+			page, err = manual.Page(notFoundStyle+resolution.String()+"\x1b[0m\n"), nil
+		}
+
+		// Other sections can be cycled through even if the page isn't from man
+		var sections []string
+		if cmd.Kind == cmds.Simple {
+			defaultCmd := *cmd
+			defaultCmd.Section = ""
+			if name, _, whichErr := (manual.Man{}).Which(ctx, &defaultCmd); whichErr == nil {
+				sections, _ = manual.Sections(ctx, name)
+			}
+		}
+
+		return func(view *gocui.View) {
+			box.section, box.sections = section, sections
+			view.Title = title
+			if err == nil {
+				view.Write(page)
+			}
+		}
 	}
+}
 
-	return nil
+// nextSection shows the manual page in the next section that has one for the
+// command, such as printf(3) after printf(1).
+func nextSection(box *Manual) func(gui *gocui.Gui, view *gocui.View) error {
+	return func(gui *gocui.Gui, view *gocui.View) error {
+		if box.cmd == nil || len(box.sections) == 0 {
+			return nil
+		}
+
+		i := 0
+		for k, section := range box.sections {
+			if section == box.section {
+				i = (k + 1) % len(box.sections)
+			}
+		}
+		if box.sections[i] == box.section {
+			return nil
+		}
+
+		cmd := *box.cmd
+		cmd.Section = box.sections[i]
+		box.load(gui, view, &cmd)
+
+		return nil
+	}
 }
 
 func toggleOff(box *Manual) func(gui *gocui.Gui, _ *gocui.View) error {
@@ -1,6 +1,7 @@
 package boxes
 
 import (
+	"context"
 	"errors"
 	"reflect"
 
@@ -15,7 +16,10 @@ type Options struct {
 	refName string
 	unit    util.Coordinates
 	script  *Script
+	command string
 	options []string
+	width   int // width the options are formatted for
+	loader  loader
 }
 
 // NewOptions creates a new options box.
@@ -78,7 +82,7 @@ func (box *Options) SetViews(gui *gocui.Gui, active bool) error {
 	return nil
 }
 
-// Update updates the options if they have changed.
+// Update updates the options if they have changed or the box is resized.
 func (box *Options) Update(gui *gocui.Gui, active bool) error {
 	view, err := gui.View(box.Name())
 	if err != nil {
@@ -86,25 +90,43 @@ func (box *Options) Update(gui *gocui.Gui, active bool) error {
 	}
 
 	cmd, err := box.script.Command()
-	if err != nil {
+	if err != nil || cmd.Name == "" {
+		box.loader.stop()
 		view.Clear()
+		view.Title = noCommandTitle(box.Name(), err)
+		box.command = ""
 		box.options = []string{}
 		return nil
 	}
 
-	if reflect.DeepEqual(cmd.Options, box.options) {
+	options := []string{}
+	for _, opt := range cmd.Options {
+		options = append(options, opt.String())
+	}
+	maxX, _ := view.Size()
+	unchanged := cmd.FullName() == box.command && reflect.DeepEqual(options, box.options)
+	if unchanged && maxX == box.width {
 		return nil
 	}
 
-	view.Clear()
-	view.SetOrigin(0, 0)
-	view.SetCursor(0, 0)
+	box.command = cmd.FullName()
+	box.options = options
+	box.width = maxX
+	get := func(ctx context.Context) func(view *gocui.View) {
+		page, provider, err := manual.GetOptions(ctx, cmd, maxX)
+		return func(view *gocui.View) {
+			view.Title = providerTitle(box.Name(), provider)
+			if err == nil {
+				view.Write(page)
+			}
+		}
+	}
 
-	box.options = cmd.Options
-	maxX, _ := view.Size()
-	page, err := manual.GetOptions(cmd, maxX)
-	if err == nil {
-		view.Write(page)
+	// Options are formatted again for a resized view in the same place
+	if unchanged {
+		box.loader.reload(gui, view, get)
+	} else {
+		box.loader.load(gui, view, box.command, get)
 	}
 
 	return nil
@@ -1,7 +1,8 @@
 package boxes
 
 import (
-	"errors"
+	"path/filepath"
+	"unicode/utf8"
 
 	"github.com/bryce/bashly/boxes/util"
 	"github.com/bryce/bashly/cmds"
@@ -13,7 +14,10 @@ type Script struct {
 	name    string
 	unit    util.Coordinates
 	tabSize int
+	script  cmds.Script // parse of the text, updated as it is edited
 	command *cmds.Command
+	err     error  // why there is no command, if there isn't one
+	dir     string // directory of the script file, "" if not known
 }
 
 // NewScript creates a new script box.
@@ -29,6 +33,14 @@ func NewScript(cfg *Config) *Script {
 	return box
 }
 
+// SetFile sets the file the script is read from, whose directory relative
+// commands are found from.
+func (box *Script) SetFile(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		box.dir = filepath.Dir(abs)
+	}
+}
+
 // Name returns the name associated with this box.
 func (box *Script) Name() string {
 	return box.name
@@ -79,20 +91,25 @@ func (box *Script) Update(gui *gocui.Gui, active bool) error {
 
 	x, y := view.Cursor()
 	line, _ := view.Line(y)
-	if x > len(line) {
-		box.command = nil
+	if x > utf8.RuneCountInString(line) {
+		box.command, box.err = nil, cmds.ErrNoCommand
 	} else {
-		offset := util.PositionIndex(view, x, y)
-		box.command, _ = cmds.Find(view.Buffer(), offset)
+		box.script.Update(view.Buffer())
+		box.command, box.err = box.script.FindAt(util.BufferPosition(view, x, y))
 	}
 
 	return nil
 }
 
-// Command gets the current command being worked on in the script.
+// Command gets the current command being worked on in the script. If there
+// is none, the error tells why, such as a *cmds.HeredocError when the cursor
+// is in the body of a heredoc.
 func (box *Script) Command() (*cmds.Command, error) {
 	if box.command == nil {
-		return nil, errors.New("no command")
+		if box.err != nil {
+			return nil, box.err
+		}
+		return nil, cmds.ErrNoCommand
 	}
 
 	return box.command, nil
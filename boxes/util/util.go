@@ -1,6 +1,7 @@
 package util
 
 import (
+	"github.com/bryce/bashly/cmds"
 	"github.com/jroimartin/gocui"
 )
 
@@ -30,35 +31,45 @@ func ScrollUp(_ *gocui.Gui, view *gocui.View) error {
 
 // PositionIndex gets the index in the string representation of the view's buffer of a given position.
 func PositionIndex(view *gocui.View, x, y int) int {
+	line, col := BufferPosition(view, x, y)
+	return cmds.Offset(view.Buffer(), line, col)
+}
+
+// BufferPosition gets the line and column in the view's buffer of a given position, both counting from 1.
+// The column counts runes, so it can be converted to an index with cmds.Offset.
+func BufferPosition(view *gocui.View, x, y int) (int, int) {
 	_, oy := view.Origin()
 	y += oy
-	idx := 0
 
 	width, _ := view.Size()
-	tmpX := 0
+	line, col := 1, 1
+	tmpX, tmpY := 0, 0
 
 	for _, val := range view.Buffer() {
-		if x <= 0 && y <= 0 {
+		// Long lines wrap onto the next row of the view
+		if val != '\n' && tmpX >= width {
+			tmpY++
+			tmpX = 0
+		}
+		if tmpY > y || tmpY == y && tmpX >= x {
 			break
 		}
 
-		if tmpX >= width {
-			y--
+		if val == '\n' {
+			if tmpY == y {
+				break
+			}
+			tmpY++
 			tmpX = 0
+			line++
+			col = 1
 			continue
 		}
 		tmpX++
-
-		if val == '\n' {
-			y--
-			tmpX = 0
-		} else if y == 0 {
-			x--
-		}
-		idx++
+		col++
 	}
 
-	return idx
+	return line, col
 }
 
 // IndexPosition gets the position of a given index in the string representation of the view's buffer.
@@ -71,12 +82,16 @@ func IndexPosition(view *gocui.View, index int) (int, int) {
 		if i >= index {
 			break
 		}
-		if val == '\n' || x >= width {
+		if val == '\n' {
+			y++
+			x = 0
+			continue
+		}
+		if x >= width {
 			y++
 			x = 0
-		} else {
-			x++
 		}
+		x++
 	}
 
 	return x, y
@@ -0,0 +1,539 @@
+package cmds
+
+// Pos is a byte offset in a bash script.
+type Pos int
+
+// Node is an element of the syntax tree of a bash script.
+type Node interface {
+	Pos() Pos // position of the first byte of the node
+	End() Pos // position of the first byte after the node
+}
+
+// File is the syntax tree of a bash script.
+type File struct {
+	List     *List
+	Comments []*Comment
+}
+
+// Comment is a comment, starting at a '#' and running to the end of the line.
+type Comment struct {
+	Hash     Pos
+	ValueEnd Pos
+	Text     string
+}
+
+// Token is an operator or delimiter in a bash script.
+type Token struct {
+	Value              string
+	ValuePos, ValueEnd Pos
+}
+
+// List is a sequence of and-or lists separated by ';', '&' or newlines.
+type List struct {
+	Items []*AndOr
+}
+
+// AndOr is a sequence of pipelines joined by the && and || operators.
+type AndOr struct {
+	Pipelines []*Pipeline
+	Ops       []Token // Ops[i] joins Pipelines[i] and Pipelines[i+1]
+	Sep       Token   // terminating ';' or '&', if any
+}
+
+// Pipeline is a sequence of commands joined by the | and |& operators.
+type Pipeline struct {
+	Bang Token // '!' negating the pipeline, if any
+	Cmds []Cmd
+	Ops  []Token // Ops[i] joins Cmds[i] and Cmds[i+1]
+}
+
+// Cmd is a simple or compound command in the syntax tree.
+type Cmd interface {
+	Node
+	cmdNode()
+}
+
+// SimpleCommand is a sequence of variable assignments, words and
+// redirections.
+type SimpleCommand struct {
+	Assigns   []*Assign
+	Words     []*Word
+	Redirects []*Redirect
+}
+
+// Assign is a variable assignment ('name=value' or 'name=(values)').
+type Assign struct {
+	Name  *Lit
+	Op    Token // '=' or '+='
+	Value *Word // nil if the value is empty or an array
+	Array *Array
+}
+
+// Array is a list of words assigned to an array variable.
+type Array struct {
+	Lparen, Rparen Token
+	Elems          []*Word
+}
+
+// Subshell is a list of commands run in a subshell ('( list )').
+type Subshell struct {
+	Lparen, Rparen Token
+	List           *List
+	Redirects      []*Redirect
+}
+
+// Block is a list of commands grouped in braces ('{ list; }').
+type Block struct {
+	Lbrace, Rbrace Token
+	List           *List
+	Redirects      []*Redirect
+}
+
+// IfClause is an if command ('if list; then list; [elif list; then list;]...
+// [else list;] fi').
+type IfClause struct {
+	If, Then, Fi Token
+	Cond, Body   *List
+	Elifs        []*Elif
+	Else         Token // empty if there is no else branch
+	ElseBody     *List
+	Redirects    []*Redirect
+}
+
+// Elif is an elif branch of an if command.
+type Elif struct {
+	Elif, Then Token
+	Cond, Body *List
+}
+
+// WhileClause is a while or until loop ('while list; do list; done').
+type WhileClause struct {
+	While, Do, Done Token // While is 'while' or 'until'
+	Cond, Body      *List
+	Redirects       []*Redirect
+}
+
+// ForClause is a for or select loop ('for name [in words]; do list; done'),
+// or an arithmetic for loop ('for ((expr; expr; expr)); do list; done').
+type ForClause struct {
+	For       Token // 'for' or 'select'
+	Name      *Lit  // nil for an arithmetic for loop
+	In        Token // empty if there is no word list
+	Words     []*Word
+	Arithm    *ArithmCmd
+	Do, Done  Token
+	Body      *List
+	Redirects []*Redirect
+}
+
+// CaseClause is a case command ('case word in [pattern) list ;;]... esac').
+type CaseClause struct {
+	Case, In, Esac Token
+	Word           *Word
+	Items          []*CaseItem
+	Redirects      []*Redirect
+}
+
+// CaseItem is a list of patterns and the commands run if one matches.
+type CaseItem struct {
+	Patterns []*Word
+	Rparen   Token
+	Body     *List
+	Op       Token // ';;', ';&' or ';;&', empty for the last item
+}
+
+// FuncDecl is a function definition ('name () command' or
+// 'function name [()] command').
+type FuncDecl struct {
+	Function Token // empty if the function keyword isn't used
+	Name     *Lit
+	Body     Cmd
+}
+
+// Coproc is a command run as a coprocess ('coproc [name] command'). The
+// name can only be given for a compound command.
+type Coproc struct {
+	Coproc Token
+	Name   *Lit // nil if the default name is used
+	Cmd    Cmd
+}
+
+// TestClause is a conditional command ('[[ expression ]]'). Operators in the
+// expression are kept as literal words.
+type TestClause struct {
+	Left, Right Token
+	Words       []*Word
+	Redirects   []*Redirect
+}
+
+// ArithmCmd is an arithmetic command ('(( expression ))').
+type ArithmCmd struct {
+	Left, Right Token
+	Parts       []WordPart // expression, which can hold substitutions
+	Redirects   []*Redirect
+}
+
+// Word is a shell word made of literal, quoted and substituted parts.
+type Word struct {
+	Parts []WordPart
+}
+
+// WordPart is a part of a word.
+type WordPart interface {
+	Node
+	wordPartNode()
+}
+
+// Lit is a literal part of a word, with escapes and line continuations removed.
+type Lit struct {
+	Value              string
+	ValuePos, ValueEnd Pos
+}
+
+// SglQuoted is a single quoted string ('...'), or an ANSI-C quoted string
+// ($'...') with its backslash escapes replaced.
+type SglQuoted struct {
+	Left, Right Token
+	Value       string
+	Dollar      bool // whether it is ANSI-C quoted
+}
+
+// DblQuoted is a double quoted string ("...").
+type DblQuoted struct {
+	Left, Right Token
+	Parts       []WordPart
+}
+
+// CmdSubst is a command substitution ('$(list)' or '`list`').
+type CmdSubst struct {
+	Left, Right Token
+	List        *List
+	Backquotes  bool
+}
+
+// ArithmExp is an arithmetic expansion ('$(( expression ))').
+type ArithmExp struct {
+	Left, Right Token
+	Parts       []WordPart // expression, which can hold substitutions
+}
+
+// ProcSubst is a process substitution ('<(list)' or '>(list)').
+type ProcSubst struct {
+	Left, Right Token
+	List        *List
+}
+
+// Redirect is a redirection of a file descriptor.
+type Redirect struct {
+	N    *Lit // file descriptor, if given
+	Op   Token
+	Word *Word
+	Hdoc *Heredoc // body of a '<<' or '<<-' redirection
+}
+
+// Heredoc is the body of a heredoc, which starts on the line after its
+// redirection and ends with a line holding only the delimiter.
+type Heredoc struct {
+	ValuePos, ValueEnd Pos // the body, without the delimiter line
+	Parts              []WordPart
+	Delim              Token
+}
+
+// Pos returns the position of the first byte of the token.
+func (t Token) Pos() Pos { return t.ValuePos }
+
+// End returns the position of the first byte after the token.
+func (t Token) End() Pos { return t.ValueEnd }
+
+// Pos returns the position of the '#'.
+func (c *Comment) Pos() Pos { return c.Hash }
+
+// End returns the position of the end of the line the comment is on.
+func (c *Comment) End() Pos { return c.ValueEnd }
+
+// Pos returns the position of the first and-or list.
+func (l *List) Pos() Pos {
+	if len(l.Items) == 0 {
+		return 0
+	}
+	return l.Items[0].Pos()
+}
+
+// End returns the position after the last and-or list.
+func (l *List) End() Pos {
+	if len(l.Items) == 0 {
+		return 0
+	}
+	return l.Items[len(l.Items)-1].End()
+}
+
+// Pos returns the position of the first pipeline.
+func (ao *AndOr) Pos() Pos { return ao.Pipelines[0].Pos() }
+
+// End returns the position after the last pipeline or the separator.
+func (ao *AndOr) End() Pos {
+	if ao.Sep.Value != "" {
+		return ao.Sep.End()
+	}
+	return ao.Pipelines[len(ao.Pipelines)-1].End()
+}
+
+// Pos returns the position of the '!' or the first command.
+func (p *Pipeline) Pos() Pos {
+	if p.Bang.Value != "" {
+		return p.Bang.Pos()
+	}
+	return p.Cmds[0].Pos()
+}
+
+// End returns the position after the last command.
+func (p *Pipeline) End() Pos { return p.Cmds[len(p.Cmds)-1].End() }
+
+// Pos returns the position of the first assignment, word or redirection.
+func (c *SimpleCommand) Pos() Pos {
+	pos := Pos(-1)
+	if len(c.Assigns) > 0 {
+		pos = c.Assigns[0].Pos()
+	} else if len(c.Words) > 0 {
+		pos = c.Words[0].Pos()
+	}
+	if len(c.Redirects) > 0 && (pos < 0 || c.Redirects[0].Pos() < pos) {
+		pos = c.Redirects[0].Pos()
+	}
+	return pos
+}
+
+// End returns the position after the last assignment, word or redirection.
+func (c *SimpleCommand) End() Pos {
+	end := Pos(-1)
+	if len(c.Words) > 0 {
+		end = c.Words[len(c.Words)-1].End()
+	} else if len(c.Assigns) > 0 {
+		end = c.Assigns[len(c.Assigns)-1].End()
+	}
+	if len(c.Redirects) > 0 && c.Redirects[len(c.Redirects)-1].End() > end {
+		end = c.Redirects[len(c.Redirects)-1].End()
+	}
+	return end
+}
+
+// Pos returns the position of the variable name.
+func (a *Assign) Pos() Pos { return a.Name.Pos() }
+
+// End returns the position after the value.
+func (a *Assign) End() Pos {
+	switch {
+	case a.Value != nil:
+		return a.Value.End()
+	case a.Array != nil:
+		return a.Array.End()
+	}
+	return a.Op.End()
+}
+
+// Pos returns the position of the opening parenthesis.
+func (a *Array) Pos() Pos { return a.Lparen.Pos() }
+
+// End returns the position after the closing parenthesis.
+func (a *Array) End() Pos { return a.Rparen.End() }
+
+// Pos returns the position of the opening parenthesis.
+func (s *Subshell) Pos() Pos { return s.Lparen.Pos() }
+
+// End returns the position after the closing parenthesis or last redirection.
+func (s *Subshell) End() Pos { return redirectsEnd(s.Rparen.End(), s.Redirects) }
+
+// Pos returns the position of the opening brace.
+func (b *Block) Pos() Pos { return b.Lbrace.Pos() }
+
+// End returns the position after the closing brace or last redirection.
+func (b *Block) End() Pos { return redirectsEnd(b.Rbrace.End(), b.Redirects) }
+
+// Pos returns the position of the if keyword.
+func (c *IfClause) Pos() Pos { return c.If.Pos() }
+
+// End returns the position after the fi keyword or last redirection.
+func (c *IfClause) End() Pos { return redirectsEnd(c.Fi.End(), c.Redirects) }
+
+// Pos returns the position of the while or until keyword.
+func (c *WhileClause) Pos() Pos { return c.While.Pos() }
+
+// End returns the position after the done keyword or last redirection.
+func (c *WhileClause) End() Pos { return redirectsEnd(c.Done.End(), c.Redirects) }
+
+// Pos returns the position of the for or select keyword.
+func (c *ForClause) Pos() Pos { return c.For.Pos() }
+
+// End returns the position after the done keyword or last redirection.
+func (c *ForClause) End() Pos { return redirectsEnd(c.Done.End(), c.Redirects) }
+
+// Pos returns the position of the case keyword.
+func (c *CaseClause) Pos() Pos { return c.Case.Pos() }
+
+// End returns the position after the esac keyword or last redirection.
+func (c *CaseClause) End() Pos { return redirectsEnd(c.Esac.End(), c.Redirects) }
+
+// Pos returns the position of the function keyword or the name.
+func (d *FuncDecl) Pos() Pos {
+	if d.Function.Value != "" {
+		return d.Function.Pos()
+	}
+	return d.Name.Pos()
+}
+
+// End returns the position after the body.
+func (d *FuncDecl) End() Pos {
+	if d.Body == nil {
+		return d.Name.End()
+	}
+	return d.Body.End()
+}
+
+// Pos returns the position of the coproc keyword.
+func (c *Coproc) Pos() Pos { return c.Coproc.Pos() }
+
+// End returns the position after the command run as a coprocess.
+func (c *Coproc) End() Pos {
+	switch {
+	case c.Cmd != nil:
+		return c.Cmd.End()
+	case c.Name != nil:
+		return c.Name.End()
+	}
+	return c.Coproc.End()
+}
+
+// Pos returns the position of the opening brackets.
+func (c *TestClause) Pos() Pos { return c.Left.Pos() }
+
+// End returns the position after the closing brackets or last redirection.
+func (c *TestClause) End() Pos { return redirectsEnd(c.Right.End(), c.Redirects) }
+
+// Pos returns the position of the opening parentheses.
+func (c *ArithmCmd) Pos() Pos { return c.Left.Pos() }
+
+// End returns the position after the closing parentheses or last redirection.
+func (c *ArithmCmd) End() Pos { return redirectsEnd(c.Right.End(), c.Redirects) }
+
+// Pos returns the position of the first part of the word.
+func (w *Word) Pos() Pos { return w.Parts[0].Pos() }
+
+// End returns the position after the last part of the word.
+func (w *Word) End() Pos { return w.Parts[len(w.Parts)-1].End() }
+
+// Lit returns the value of the word if it is made only of literal and
+// quoted parts, and "" otherwise.
+func (w *Word) Lit() string {
+	value, _ := lit(w.Parts)
+	return value
+}
+
+func lit(parts []WordPart) (string, bool) {
+	value := ""
+	for _, part := range parts {
+		switch part := part.(type) {
+		case *Lit:
+			value += part.Value
+		case *SglQuoted:
+			value += part.Value
+		case *DblQuoted:
+			quoted, ok := lit(part.Parts)
+			if !ok {
+				return "", false
+			}
+			value += quoted
+		default:
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// Pos returns the position of the first byte of the literal.
+func (l *Lit) Pos() Pos { return l.ValuePos }
+
+// End returns the position after the literal.
+func (l *Lit) End() Pos { return l.ValueEnd }
+
+// Pos returns the position of the opening quote.
+func (q *SglQuoted) Pos() Pos { return q.Left.Pos() }
+
+// End returns the position after the closing quote.
+func (q *SglQuoted) End() Pos { return q.Right.End() }
+
+// Pos returns the position of the opening quote.
+func (q *DblQuoted) Pos() Pos { return q.Left.Pos() }
+
+// End returns the position after the closing quote.
+func (q *DblQuoted) End() Pos { return q.Right.End() }
+
+// Pos returns the position of the opening delimiter.
+func (s *CmdSubst) Pos() Pos { return s.Left.Pos() }
+
+// End returns the position after the closing delimiter.
+func (s *CmdSubst) End() Pos { return s.Right.End() }
+
+// Pos returns the position of the opening delimiter.
+func (e *ArithmExp) Pos() Pos { return e.Left.Pos() }
+
+// End returns the position after the closing delimiter.
+func (e *ArithmExp) End() Pos { return e.Right.End() }
+
+// Pos returns the position of the opening delimiter.
+func (s *ProcSubst) Pos() Pos { return s.Left.Pos() }
+
+// End returns the position after the closing parenthesis.
+func (s *ProcSubst) End() Pos { return s.Right.End() }
+
+// Pos returns the position of the file descriptor or the operator.
+func (r *Redirect) Pos() Pos {
+	if r.N != nil {
+		return r.N.Pos()
+	}
+	return r.Op.Pos()
+}
+
+// End returns the position after the target word.
+func (r *Redirect) End() Pos {
+	if r.Word != nil {
+		return r.Word.End()
+	}
+	return r.Op.End()
+}
+
+// Pos returns the position of the first byte of the body.
+func (h *Heredoc) Pos() Pos { return h.ValuePos }
+
+// End returns the position after the delimiter line.
+func (h *Heredoc) End() Pos {
+	if h.Delim.Value != "" {
+		return h.Delim.End()
+	}
+	return h.ValueEnd
+}
+
+func redirectsEnd(end Pos, redirects []*Redirect) Pos {
+	if len(redirects) > 0 {
+		return redirects[len(redirects)-1].End()
+	}
+	return end
+}
+
+func (*SimpleCommand) cmdNode() {}
+func (*Subshell) cmdNode()      {}
+func (*Block) cmdNode()         {}
+func (*IfClause) cmdNode()      {}
+func (*WhileClause) cmdNode()   {}
+func (*ForClause) cmdNode()     {}
+func (*CaseClause) cmdNode()    {}
+func (*FuncDecl) cmdNode()      {}
+func (*Coproc) cmdNode()        {}
+func (*TestClause) cmdNode()    {}
+func (*ArithmCmd) cmdNode()     {}
+
+func (*Lit) wordPartNode()       {}
+func (*SglQuoted) wordPartNode() {}
+func (*DblQuoted) wordPartNode() {}
+func (*CmdSubst) wordPartNode()  {}
+func (*ArithmExp) wordPartNode() {}
+func (*ProcSubst) wordPartNode() {}
@@ -0,0 +1,84 @@
+package cmds
+
+import "strings"
+
+// Definitions holds the functions and aliases defined in a script.
+type Definitions struct {
+	Functions map[string]int    // line each function is defined on, by name
+	Aliases   map[string]string // value of each alias, by name
+}
+
+// FindDefinitions returns the functions and aliases defined in a script.
+// A name defined more than once keeps its last definition, as it does when
+// the script runs. If the script has a syntax error, the definitions found in
+// the rest of it are returned along with the error.
+func FindDefinitions(script string) (*Definitions, error) {
+	file, err := Parse(script)
+
+	f := &Finder{script: script, offset: -1, all: true}
+	f.list(file.List)
+
+	return f.definitions(0), err
+}
+
+// Definitions returns the functions and aliases defined in the script, as
+// FindDefinitions does. Only the parts of the script that changed since the
+// last call are looked through again.
+func (s *Script) Definitions() *Definitions {
+	defs := newDefinitions()
+	s.settle(len(s.chunks))
+	for _, c := range s.chunks {
+		// The lines of functions are kept from the start of the chunk, so they
+		// stay right when the chunk moves
+		if c.defs == nil {
+			items := s.items(c)
+			f := s.finder(c, 1)
+			f.all = true
+			f.list(&List{Items: items})
+			c.defs = f.definitions(0)
+		}
+
+		for name, line := range c.defs.Functions {
+			defs.Functions[name] = c.line - 1 + line
+		}
+		for name, value := range c.defs.Aliases {
+			defs.Aliases[name] = value
+		}
+	}
+
+	return defs
+}
+
+// newDefinitions returns empty definitions.
+func newDefinitions() *Definitions {
+	return &Definitions{Functions: map[string]int{}, Aliases: map[string]string{}}
+}
+
+// definitions returns the functions and aliases collected by the finder,
+// with the lines of the functions counted after the line given.
+func (f *Finder) definitions(line int) *Definitions {
+	defs := newDefinitions()
+	for _, decl := range f.funcs {
+		if decl.Name != nil && decl.Name.Value != "" {
+			defs.Functions[decl.Name.Value] = f.line(int(decl.Pos())) - line
+		}
+	}
+
+	// Aliases are defined in the order the commands run in
+	for i, simple := range f.simples {
+		if len(simple.Words) == 0 || simple.Words[0].Lit() != "alias" {
+			continue
+		}
+		cmd, err := f.newCommand(simple, f.pipelines[i])
+		if err != nil || cmd.Name != "alias" || len(cmd.Wrappers) > 0 {
+			continue
+		}
+		for _, arg := range cmd.Args {
+			if eq := strings.IndexByte(arg, '='); eq > 0 {
+				defs.Aliases[arg[:eq]] = arg[eq+1:]
+			}
+		}
+	}
+
+	return defs
+}
@@ -0,0 +1,75 @@
+package cmds
+
+import "testing"
+
+func TestFindDefinitions(t *testing.T) {
+	script := `#!/bin/bash
+alias ll='ls -l' la="ls -a"
+deploy() {
+	rsync -a . "$1"
+}
+if true; then
+	function cleanup {
+		rm -rf "$tmp"
+	}
+fi
+alias ll='ls -lh'
+sudo alias x=y
+deploy prod
+`
+	defs, err := FindDefinitions(script)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	testDefinitions(t, defs, 0)
+
+	// A script being edited only looks through the chunks that changed, and
+	// the functions after an edit move with it
+	s := &Script{}
+	s.Update(script)
+	testDefinitions(t, s.Definitions(), 0)
+	deploy := chunkDefining(s, "deploy")
+	s.Update("# setup\n\n" + script)
+	testDefinitions(t, s.Definitions(), 2)
+	if deploy == nil || chunkDefining(s, "deploy") != deploy {
+		t.Error("Expected the definitions of a moved chunk to be kept")
+	}
+}
+
+// chunkDefining returns the definitions of the chunk of the script that
+// defines a function.
+func chunkDefining(s *Script, function string) *Definitions {
+	for _, c := range s.chunks {
+		if _, ok := c.defs.Functions[function]; ok {
+			return c.defs
+		}
+	}
+
+	return nil
+}
+
+// testDefinitions checks the definitions of the script in TestFindDefinitions,
+// with the given number of lines added before it.
+func testDefinitions(t *testing.T, defs *Definitions, added int) {
+	t.Helper()
+
+	functions := map[string]int{"deploy": 3 + added, "cleanup": 7 + added}
+	if len(defs.Functions) != len(functions) {
+		t.Error("Expected functions", functions, "got", defs.Functions)
+	}
+	for name, line := range functions {
+		if defs.Functions[name] != line {
+			t.Errorf("%s: expected line %d, got %d", name, line, defs.Functions[name])
+		}
+	}
+
+	aliases := map[string]string{"ll": "ls -lh", "la": "ls -a"}
+	if len(defs.Aliases) != len(aliases) {
+		t.Error("Expected aliases", aliases, "got", defs.Aliases)
+	}
+	for name, value := range aliases {
+		if defs.Aliases[name] != value {
+			t.Errorf("%s: expected alias %q, got %q", name, value, defs.Aliases[name])
+		}
+	}
+}
@@ -1,302 +1,485 @@
 /*
-Package cmds implements functionality for finding commands in bash scripts.
+Package cmds implements functionality for parsing bash scripts and finding
+the commands in them.
 */
 package cmds
 
 import (
 	"errors"
-	"regexp"
+	"sort"
 	"strings"
-	"unicode"
-
-	"github.com/bryce/bashly/util"
 )
 
-type state int
+// Kind is the kind of a command.
+type Kind int
+
+// Kinds of commands.
+const (
+	Simple   Kind = iota // command run by name
+	Reserved             // reserved word of a compound command, such as if
+)
 
-// Command holds information about a command.
+// Command holds information about a command. For a reserved word, the name is
+// the word that starts the compound command, such as if for then and fi.
 type Command struct {
-	Name    string
-	Options []string
+	Name        string
+	Kind        Kind
+	Subcommands []string // subcommands, such as commit in git commit
+	Options     []Option
+	Args        []string      // positional arguments
+	Redirects   []Redirection // redirections of the command line, shared by its wrappers
+	Env         []string      // variable assignments for the command, as NAME=value
+	Wrappers    []*Command    // commands that run this one, such as sudo, outermost first
+	Wrapped     *Command      // command run by this one, if it is a wrapper
+	Start, End  int           // byte range of the name, subcommands and options in the script
+	Line        int           // line the command starts on, counting from 1
+	Pipeline    *Pipeline     // pipeline the command is part of
+	Section     string        // section of the manual page to show, "" for the default
 }
 
-// Finder holds data for finding command names in a bash script.
-type Finder struct {
-	pos, offset  int
-	line         []rune
-	cmds, delims util.Stack
-	state        state
+// FullName returns the name of the command followed by its subcommands.
+func (cmd *Command) FullName() string {
+	return strings.Join(append([]string{cmd.Name}, cmd.Subcommands...), " ")
 }
 
-const (
-	command      state = iota // we are processing a command string
-	options                   // we are processing an option
-	throwaway                 // we are processing runes that can be thrown away
-	separator                 // we are processing a command separator string
-	substitution              // we are processing a substitution string
-	quote                     // we are processing a single quoted string ('...')
-)
+// Inner returns the command that is run in the end by a chain of wrappers.
+func (cmd *Command) Inner() *Command {
+	for cmd.Wrapped != nil {
+		cmd = cmd.Wrapped
+	}
+
+	return cmd
+}
+
+// Finder holds data for finding the command at an offset in a parsed script.
+type Finder struct {
+	script   string
+	offset   Pos
+	cmd      *SimpleCommand
+	heredoc  Cmd // command whose heredoc body the offset is in
+	inDelim  bool
+	keyword  string    // compound command whose reserved word the offset is on
+	reserved Token     // reserved word the offset is on
+	pipeline *Pipeline // pipeline being walked
+	found    *Pipeline // pipeline of cmd or the reserved word
+	lines    []int     // offsets from base that lines start at, made for the whole script when first needed
+	base     int       // offset the lines are counted from
+	first    int       // line at base, counting from 1
+
+	// Set to collect every simple command instead of the one at the offset
+	all       bool
+	simples   []*SimpleCommand
+	pipelines []*Pipeline
+	funcs     []*FuncDecl
+}
 
+// Errors returned when there is no command at the offset.
 var (
-	regexSeparator    = regexp.MustCompile(`^(\|[|&]?|&&|;)`)
-	regexSubstitution = regexp.MustCompile(`^(\$\(|\)|\\*` + "`" + `)`)
+	ErrEmptyScript = errors.New("empty script")
+	ErrNoCommand   = errors.New("no command")
 )
 
-// Find returns the name of the command being worked on at offset.
-func Find(script string, offset int) (*Command, error) {
-	if len(script) <= 1 {
-		return nil, errors.New("empty script")
-	}
+// HeredocError is the error returned by Find when the offset is in the body
+// of a heredoc rather than on a command.
+type HeredocError struct {
+	Name string // name of the command the heredoc is for, if known
+}
 
-	f := &Finder{offset: offset, delims: util.Stack{}, cmds: util.Stack{}, state: command}
-
-	// Find the line in which the offset is located
-	re := regexp.MustCompile(`.*\n`)
-	matches := re.FindAllString(script, -1)
-	re2 := regexp.MustCompile(`\\\n`)
-	line := ""
-	for _, match := range matches {
-		line += match
-		if !re2.MatchString(match) || strings.Contains(match, "#") {
-			if f.offset >= len(line) {
-				f.offset -= len(line)
-				line = ""
-			} else {
-				line = line[:len(line)-1]
-				break
-			}
-		}
+func (e *HeredocError) Error() string {
+	if e.Name == "" {
+		return "inside heredoc"
 	}
+	return "inside heredoc for " + e.Name
+}
 
-	if len(line) <= 0 {
-		return nil, errors.New("empty line")
-	}
+// Find returns the command being worked on at offset. Syntax errors in the
+// script still leave commands that can be found, but if there is no command
+// at the offset, the first syntax error on its lines is returned, as an
+// *Error, rather than ErrNoCommand.
+func Find(script string, offset int) (*Command, error) {
+	s := &Script{}
+	s.Update(script)
+
+	return s.Find(offset)
+}
 
-	f.line = []rune(line)
-	for f.pos < f.offset || f.state == command {
-		switch f.state {
-		case command:
-			f.command()
-		case throwaway:
-			f.throwaway()
-		case separator:
-			f.separator()
-		case substitution:
-			f.substitution()
-		case quote:
-			f.quote()
+// find returns the command at the offset in a list of the script. err is the
+// syntax error to return if there is no command.
+func (f *Finder) find(list *List, err error) (*Command, error) {
+	f.list(list)
+	if f.keyword != "" && !f.inDelim {
+		start := int(f.reserved.Pos())
+		return &Command{Name: f.keyword, Kind: Reserved, Start: start, End: int(f.reserved.End()),
+			Line: f.line(start), Pipeline: f.found}, nil
+	}
+	if f.cmd == nil && f.heredoc != nil {
+		name := ""
+		if simple, ok := f.heredoc.(*SimpleCommand); ok && len(simple.Words) > 0 {
+			name = simple.Words[0].Lit()
 		}
+		return nil, &HeredocError{Name: name}
 	}
-
-	val, _ := f.cmds.Top()
-	cmd := val.(*Command)
-	if cmd.Name == "" {
-		return nil, errors.New("no command")
+	if f.inDelim {
+		return nil, ErrNoCommand
+	}
+	if f.cmd == nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNoCommand
 	}
 
-	return cmd, nil
-
+	return f.newCommand(f.cmd, f.found)
 }
 
-func (f *Finder) command() {
-	cmd := &Command{}
+// FindAll returns every command in the script, in the order they start in.
+// Commands run by wrappers are reached through the Wrapped field of the
+// outermost wrapper. If the script has a syntax error, the commands found
+// in the rest of it are returned along with the error.
+func FindAll(script string) ([]*Command, error) {
+	file, err := Parse(script)
 
-	for f.state == command || f.state == options {
-		if f.pos >= len(f.line) {
-			f.state = throwaway
-			break
-		}
+	f := &Finder{script: script, offset: -1, all: true}
+	f.list(file.List)
 
-		line := f.line[f.pos:]
-
-		switch {
-		case isSpace(line):
-			if f.state == command && len(cmd.Name) > 0 {
-				cmd.Options = append(cmd.Options, "")
-				f.state = options
-			} else if f.state == options && cmd.Options[len(cmd.Options)-1] != "" {
-				cmd.Options = append(cmd.Options, "")
-			}
-			f.pos++
-		case isMultiline(line):
-			f.pos += 2
-		case isComment(line):
-			f.state = throwaway
-		case isSeparator(line):
-			f.state = separator
-		case isSubstitution(line):
-			f.state = substitution
-		default:
-			if f.state == command {
-				cmd.Name += string(line[0])
-				f.pos++
-			} else if f.state == options {
-				if cmd.Options[len(cmd.Options)-1] == "" && line[0] != '-' {
-					cmd.Options = cmd.Options[:len(cmd.Options)-1]
-					f.state = throwaway
-				} else {
-					cmd.Options[len(cmd.Options)-1] += string(line[0])
-					f.pos++
-				}
-			}
+	var all []*Command
+	for i, simple := range f.simples {
+		if cmd, cmdErr := f.newCommand(simple, f.pipelines[i]); cmdErr == nil && cmd.Name != "" {
+			all = append(all, cmd)
 		}
 	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Start < all[j].Start })
 
-	f.cmds.Push(cmd)
+	return all, err
 }
 
-func (f *Finder) throwaway() {
-	for f.state == throwaway {
-		if f.pos >= f.offset {
-			break
+// newCommand creates the command for a simple command in the script. A simple
+// command with only assignments gives a command with no name. If the command
+// is run by wrappers, the part of the chain that the offset is on is returned.
+func (f *Finder) newCommand(simple *SimpleCommand, pipeline *Pipeline) (*Command, error) {
+	var env []string
+	for _, assign := range simple.Assigns {
+		value := ""
+		switch {
+		case assign.Value != nil && assign.Value.Lit() != "":
+			value = assign.Value.Lit()
+		case assign.Value != nil:
+			value = f.script[assign.Value.Pos():assign.Value.End()]
+		case assign.Array != nil:
+			value = f.script[assign.Array.Pos():assign.Array.End()]
 		}
+		env = append(env, assign.Name.Value+assign.Op.Value+value)
+	}
 
-		line := f.line[f.pos:]
+	var redirects []Redirection
+	for _, redirect := range simple.Redirects {
+		redirects = append(redirects, newRedirection(f.script, redirect))
+	}
 
-		switch {
-		case isComment(line):
-			f.cmds.Push(&Command{"", nil})
-			f.pos = f.offset
-		case isSeparator(line):
-			f.state = separator
-		case isSubstitution(line):
-			f.state = substitution
-		case isQuote(line):
-			f.state = quote
-		default:
-			f.pos++
+	if len(simple.Words) == 0 {
+		if len(env) == 0 {
+			return nil, ErrNoCommand
 		}
+		start := int(simple.Pos())
+		return &Command{Redirects: redirects, Env: env, Start: start, End: start, Line: f.line(start), Pipeline: pipeline}, nil
 	}
-}
 
-func (f *Finder) separator() {
-	token := getSeparator(f.line[f.pos:])
-	f.cmds.Pop()
-	f.state = command
-	f.consumeSeparatingToken(token)
-}
+	words := simple.Words
+	var cmd *Command
+	var chain []*Command
+	for i := 0; i < len(words) && words[i].Lit() != ""; {
+		next := &Command{Name: words[i].Lit(), Redirects: redirects, Env: env, Pipeline: pipeline}
+		next.Start, next.End = int(words[i].Pos()), int(words[i].End())
+		next.Line = f.line(next.Start)
+		n, opts, args, assigns := unwrap(words[i:])
+		if n == 0 {
+			var used int
+			next.Subcommands, next.Options, next.Args, used = subcommands(next.Name, words[i+1:])
+			if used > 0 {
+				next.End = int(words[i+used].End())
+			}
+		} else {
+			next.Options, next.Args, env = opts, args, assigns
+		}
+		for _, opt := range next.Options {
+			if opt.End > next.End {
+				next.End = opt.End
+			}
+		}
 
-func (f *Finder) substitution() {
-	token := getSubstitution(f.line[f.pos:])
-
-	switch {
-	// Case for closing substitution
-	case isClosingSubstitution(f.delims, token):
-		f.cmds.Pop()
-		f.delims.Pop()
-		f.state = throwaway
-		f.consumeSeparatingToken(token)
-	// Case for opening parenthesis
-	case isOpeningSubstitution(f.delims, token):
-		f.delims.Push(token)
-		f.state = command
-		f.consumeSeparatingToken(token)
-	default:
-		f.state = throwaway
-		f.pos += len(token)
-	}
-}
+		if len(chain) > 0 {
+			chain[len(chain)-1].Wrapped = next
+			next.Wrappers = append([]*Command{}, chain...)
+		}
+		chain = append(chain, next)
+		if cmd == nil || f.offset >= 0 && simple.Words[i].Pos() <= f.offset {
+			cmd = next
+		}
 
-func (f *Finder) quote() {
-	f.pos++
-	if f.pos >= f.offset {
-		f.state = throwaway
-		return
+		if n == 0 {
+			break
+		}
+		i += n
 	}
 
-	r := f.line[f.pos]
-	if r == '\'' {
-		f.pos++
-		f.state = throwaway
+	if cmd == nil {
+		return nil, ErrNoCommand
 	}
+
+	return cmd, nil
 }
 
-func (f *Finder) consumeSeparatingToken(token string) {
-	f.pos += len(token)
-	// Offset is in the middle of a separating token between commands
-	if f.pos > f.offset {
-		f.cmds.Push(&Command{"", nil})
-		f.state = throwaway
+// line returns the line that offset is on, counting from 1.
+func (f *Finder) line(offset int) int {
+	if f.lines == nil {
+		f.lines, f.base, f.first = lineStarts(f.script), 0, 1
 	}
-}
 
-func isSpace(line []rune) bool {
-	return unicode.IsSpace(line[0])
+	return f.first - 1 + sort.SearchInts(f.lines, offset-f.base+1)
 }
 
-func isMultiline(line []rune) bool {
-	if len(line) <= 1 {
-		return false
+// lineStarts returns the offsets that the lines of a script start at.
+func lineStarts(script string) []int {
+	starts := []int{0}
+	for i := strings.IndexByte(script, '\n'); i >= 0; {
+		starts = append(starts, starts[len(starts)-1]+i+1)
+		i = strings.IndexByte(script[starts[len(starts)-1]:], '\n')
 	}
 
-	return string(line[:2]) == "\\\n"
+	return starts
 }
 
-func isComment(line []rune) bool {
-	return line[0] == '#'
-}
-
-func isSeparator(line []rune) bool {
-	return regexSeparator.MatchString(string(line))
+func (f *Finder) list(list *List) {
+	outer := f.pipeline
+	for _, andOr := range list.Items {
+		f.delimiters(andOr.Ops...)
+		for _, pipeline := range andOr.Pipelines {
+			f.delimiters(pipeline.Ops...)
+			f.pipeline = pipeline
+			f.keywords("!", pipeline.Bang)
+			for _, cmd := range pipeline.Cmds {
+				f.command(cmd)
+			}
+		}
+	}
+	f.pipeline = outer
 }
 
-func isSubstitution(line []rune) bool {
-	return regexSubstitution.MatchString(string(line))
+func (f *Finder) command(cmd Cmd) {
+	switch cmd := cmd.(type) {
+	case *SimpleCommand:
+		if f.all {
+			f.simples = append(f.simples, cmd)
+			f.pipelines = append(f.pipelines, f.pipeline)
+		} else if f.contains(cmd) {
+			f.cmd, f.keyword, f.found = cmd, "", f.pipeline
+		}
+		for _, assign := range cmd.Assigns {
+			f.assign(assign)
+		}
+		for _, word := range cmd.Words {
+			f.word(word)
+		}
+		f.redirects(cmd, cmd.Redirects)
+	case *Subshell:
+		f.list(cmd.List)
+		f.redirects(cmd, cmd.Redirects)
+	case *Block:
+		f.keywords("{", cmd.Lbrace)
+		f.list(cmd.List)
+		f.keywords("{", cmd.Rbrace)
+		f.redirects(cmd, cmd.Redirects)
+	case *IfClause:
+		f.keywords("if", cmd.If)
+		f.list(cmd.Cond)
+		f.keywords("if", cmd.Then)
+		f.list(cmd.Body)
+		for _, elif := range cmd.Elifs {
+			f.keywords("if", elif.Elif)
+			f.list(elif.Cond)
+			f.keywords("if", elif.Then)
+			f.list(elif.Body)
+		}
+		if cmd.ElseBody != nil {
+			f.keywords("if", cmd.Else)
+			f.list(cmd.ElseBody)
+		}
+		f.keywords("if", cmd.Fi)
+		f.redirects(cmd, cmd.Redirects)
+	case *WhileClause:
+		name := cmd.While.Value
+		f.keywords(name, cmd.While)
+		f.list(cmd.Cond)
+		f.keywords(name, cmd.Do)
+		f.list(cmd.Body)
+		f.keywords(name, cmd.Done)
+		f.redirects(cmd, cmd.Redirects)
+	case *ForClause:
+		name := cmd.For.Value
+		f.keywords(name, cmd.For, cmd.In)
+		for _, word := range cmd.Words {
+			f.word(word)
+		}
+		if cmd.Arithm != nil {
+			f.arithm(name, cmd.Arithm)
+		}
+		f.keywords(name, cmd.Do)
+		f.list(cmd.Body)
+		f.keywords(name, cmd.Done)
+		f.redirects(cmd, cmd.Redirects)
+	case *CaseClause:
+		f.keywords("case", cmd.Case)
+		if cmd.Word != nil {
+			f.word(cmd.Word)
+		}
+		f.keywords("case", cmd.In)
+		for _, item := range cmd.Items {
+			for _, pattern := range item.Patterns {
+				f.word(pattern)
+			}
+			f.list(item.Body)
+			f.delimiters(item.Op)
+		}
+		f.keywords("case", cmd.Esac)
+		f.redirects(cmd, cmd.Redirects)
+	case *FuncDecl:
+		if f.all {
+			f.funcs = append(f.funcs, cmd)
+		}
+		f.keywords("function", cmd.Function)
+		if cmd.Body != nil {
+			f.command(cmd.Body)
+		}
+	case *Coproc:
+		f.keywords("coproc", cmd.Coproc)
+		if cmd.Name != nil {
+			// The name is documented with the keyword
+			f.keywords("coproc", Token{Value: cmd.Name.Value, ValuePos: cmd.Name.Pos(), ValueEnd: cmd.Name.End()})
+		}
+		if cmd.Cmd != nil {
+			f.command(cmd.Cmd)
+		}
+	case *TestClause:
+		// The whole expression is documented with the brackets
+		f.keywords("[[", Token{Value: "[[", ValuePos: cmd.Left.Pos(), ValueEnd: cmd.Right.End()})
+		for _, word := range cmd.Words {
+			f.word(word)
+		}
+		f.redirects(cmd, cmd.Redirects)
+	case *ArithmCmd:
+		f.arithm("((", cmd)
+		f.redirects(cmd, cmd.Redirects)
+	}
 }
 
-func isQuote(line []rune) bool {
-	return line[0] == '\''
+// arithm walks an arithmetic expression, which is documented with the
+// command it is part of.
+func (f *Finder) arithm(name string, arithm *ArithmCmd) {
+	f.keywords(name, Token{Value: "((", ValuePos: arithm.Left.Pos(), ValueEnd: arithm.Right.End()})
+	f.parts(arithm.Parts)
 }
 
-func getSeparator(line []rune) string {
-	return regexSeparator.FindString(string(line))
+// keywords checks whether the offset is on one of the reserved words of a
+// compound command.
+func (f *Finder) keywords(name string, tokens ...Token) {
+	for _, tok := range tokens {
+		if tok.Value != "" && tok.Pos() <= f.offset && f.offset <= tok.End() {
+			f.cmd, f.keyword, f.reserved, f.found = nil, name, tok, f.pipeline
+		}
+	}
 }
 
-func getSubstitution(line []rune) string {
-	return regexSubstitution.FindString(string(line))
+func (f *Finder) assign(assign *Assign) {
+	if assign.Value != nil {
+		f.word(assign.Value)
+	}
+	if assign.Array != nil {
+		for _, elem := range assign.Array.Elems {
+			f.word(elem)
+		}
+	}
 }
 
-func isClosingSubstitution(delims util.Stack, token string) bool {
-	val, err := delims.Top()
-	open := val.(string)
-	if err != nil {
-		return false
-	}
+func (f *Finder) redirects(cmd Cmd, redirects []*Redirect) {
+	for _, redirect := range redirects {
+		if redirect.Word != nil {
+			f.word(redirect.Word)
+		}
 
-	if open == "$(" {
-		return token == ")"
+		hdoc := redirect.Hdoc
+		if hdoc == nil {
+			continue
+		}
+		// Only substitutions in the body can hold commands
+		if hdoc.Pos() <= f.offset && f.offset <= hdoc.End() {
+			f.heredoc, f.cmd, f.keyword = cmd, nil, ""
+		}
+		f.parts(hdoc.Parts)
 	}
+}
 
-	return open == token
+func (f *Finder) word(word *Word) {
+	f.parts(word.Parts)
 }
 
-func isOpeningSubstitution(delims util.Stack, token string) bool {
-	// Case for opening parenthesis
-	if token == "$(" {
-		return true
+func (f *Finder) parts(parts []WordPart) {
+	for _, part := range parts {
+		switch part := part.(type) {
+		case *DblQuoted:
+			f.parts(part.Parts)
+		case *CmdSubst:
+			f.delimiters(part.Left, part.Right)
+			// Inside the substitution only its own commands can be found
+			if part.Left.End() <= f.offset && f.offset <= part.Right.Pos() {
+				f.cmd, f.keyword = nil, ""
+			}
+			f.list(part.List)
+		case *ProcSubst:
+			f.delimiters(part.Left, part.Right)
+			if part.Left.End() <= f.offset && f.offset <= part.Right.Pos() {
+				f.cmd, f.keyword = nil, ""
+			}
+			f.list(part.List)
+		case *ArithmExp:
+			// The expression holds no commands, only substitutions can
+			f.delimiters(part.Left, part.Right)
+			f.parts(part.Parts)
+		}
 	}
+}
 
-	// Case for opening backticks
-	if matched, _ := regexp.MatchString(`\\*`+"`", token); !matched {
-		return false
+// delimiters checks whether the offset is in the middle of one of the tokens.
+func (f *Finder) delimiters(tokens ...Token) {
+	for _, tok := range tokens {
+		if tok.Pos() < f.offset && f.offset < tok.End() {
+			f.inDelim = true
+		}
 	}
+}
 
-	val, err := delims.Top()
-	open := val.(string)
-	switch {
-	case token == "`":
-		return err != nil || !strings.Contains(open, "`")
-	case token == "\\`":
-		return open == "`"
-	default:
-		val1, val2, err := delims.Top2()
-		open1 := val1.(string)
-		open2 := val2.(string)
-		if err != nil {
-			return false
+// contains reports whether the offset is on the command or on the blanks
+// around it on the same line.
+func (f *Finder) contains(cmd *SimpleCommand) bool {
+	start, end := int(cmd.Pos()), int(cmd.End())
+
+	for start > 0 {
+		if c := f.script[start-1]; c == ' ' || c == '\t' {
+			start--
+		} else if strings.HasSuffix(f.script[:start], "\\\n") {
+			start -= 2
+		} else {
+			break
+		}
+	}
+	for end < len(f.script) {
+		if c := f.script[end]; c == ' ' || c == '\t' {
+			end++
+		} else if strings.HasPrefix(f.script[end:], "\\\n") {
+			end += 2
+		} else {
+			break
 		}
-
-		backslashes := (len(open1) - len(open2)) * 2
-		return len(token) == len(open1)+backslashes
 	}
+
+	return Pos(start) <= f.offset && f.offset <= Pos(end)
 }
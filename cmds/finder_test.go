@@ -1,6 +1,35 @@
 package cmds
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// findTest is a test case for Find, with an empty name if no command is expected.
+type findTest struct {
+	script string
+	offset int
+	name   string
+}
+
+func testFind(t *testing.T, tests []findTest) {
+	t.Helper()
+
+	for _, test := range tests {
+		cmd, err := Find(test.script, test.offset)
+		switch {
+		case test.name == "" && err == nil:
+			t.Errorf("%q at %d: expected no command, got %s", test.script, test.offset, cmd.Name)
+		case test.name != "" && err != nil:
+			t.Errorf("%q at %d: expected %s, got error %v", test.script, test.offset, test.name, err)
+		case test.name != "" && cmd.Name != test.name:
+			t.Errorf("%q at %d: expected %s, got %s", test.script, test.offset, test.name, cmd.Name)
+		}
+	}
+}
 
 func TestMultiline(t *testing.T) {
 	s := "ls|\\\n m\\\nv||echo \\\nhello\n"
@@ -156,3 +185,613 @@ func TestQuotes(t *testing.T) {
 		t.Error("Expected echo, got", cmd.Name)
 	}
 }
+
+func TestDoubleQuotes(t *testing.T) {
+	tests := []findTest{
+		{"echo \"a; b\" | grep x\n", 7, "echo"},
+		{"echo \"a; b\" | grep x\n", 14, "grep"},
+		{"echo \"# not a comment\" && ls\n", 10, "echo"},
+		{"echo \"# not a comment\" && ls\n", 26, "ls"},
+		{"echo \"a \\\" ; b\" ; ls\n", 12, "echo"},
+		{"echo \"a \\\" ; b\" ; ls\n", 18, "ls"},
+		{"echo \"$(date | tr a b)\" x\n", 9, "date"},
+		{"echo \"$(date | tr a b)\" x\n", 16, "tr"},
+		{"echo \"$(date | tr a b)\" x\n", 24, "echo"},
+		{"echo \"`ls \\\"a\\\"`\" | wc\n", 7, "ls"},
+		{"echo \"`ls \\\"a\\\"`\" | wc\n", 21, "wc"},
+		{"echo \"unterminated ; ls\n", 17, "echo"},
+		{"\"ls\" -l\n", 2, "ls"},
+	}
+
+	testFind(t, tests)
+}
+
+func TestANSICQuotes(t *testing.T) {
+	tests := []findTest{
+		{"echo $'a\\'b' ; ls\n", 8, "echo"},
+		{"echo $'a\\'b' ; ls\n", 16, "ls"},
+		{"printf '%s' $'it\\'s'\nls -l\nmv a b\n", 27, "mv"},
+		{"printf '%s' $'line\\n\nit\\'s ; not a command'\nmv a b\n", 24, "printf"},
+		{"printf '%s' $'line\\n\nit\\'s ; not a command'\nmv a b\n", 45, "mv"},
+		{"echo \"$'\" ; ls\n", 13, "ls"},
+	}
+
+	testFind(t, tests)
+
+	all, err := FindAll("printf '%s' $'it\\'s'\nls -l\nmv a b\n")
+	if err != nil || len(all) != 3 {
+		t.Error("Expected printf, ls and mv, got", all, err)
+	}
+
+	values := map[string]string{
+		`$'a\'b'`:           "a'b",
+		`$'tab\there'`:      "tab\there",
+		`$'\x41\101\u00e9'`: "AAé",
+		`$'\cA\q\x'`:        "\x01\\q\\x",
+		`x$'\n'y`:           "x\ny",
+	}
+	for word, value := range values {
+		cmd, err := Find("echo "+word+"\n", 0)
+		if err != nil || len(cmd.Args) != 1 || cmd.Args[0] != value {
+			t.Errorf("%s: expected %q, got %v (%v)", word, value, cmd, err)
+		}
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	tests := []findTest{
+		{"echo a\\;b\\|c | wc\n", 8, "echo"},
+		{"echo a\\;b\\|c | wc\n", 10, "echo"},
+		{"echo a\\;b\\|c | wc\n", 15, "wc"},
+		{"echo \\# x; ls\n", 8, "echo"},
+		{"echo \\# x; ls\n", 11, "ls"},
+		{"echo \\$(ls) x\n", 8, "echo"},
+		{"l\\s -l\n", 1, "ls"},
+	}
+
+	testFind(t, tests)
+}
+
+func TestHeredocs(t *testing.T) {
+	tests := []findTest{
+		{"cat <<EOF\nls -l\nEOF\nls\n", 2, "cat"},
+		{"cat <<EOF\nls -l\nEOF\nls\n", 11, ""},
+		{"cat <<EOF\nls -l\nEOF\nls\n", 17, ""},
+		{"cat <<EOF\nls -l\nEOF\nls\n", 20, "ls"},
+		{"cat <<-'EOF' | grep x\n\tls $(pwd)\n\tEOF\nmv\n", 16, "grep"},
+		{"cat <<-'EOF' | grep x\n\tls $(pwd)\n\tEOF\nmv\n", 28, ""},
+		{"cat <<-'EOF' | grep x\n\tls $(pwd)\n\tEOF\nmv\n", 38, "mv"},
+		{"cat <<EOF\n$(date) x\nEOF\n", 12, "date"},
+		{"cat <<EOF\n$(date) x\nEOF\n", 18, ""},
+		{"cat <<A; cat <<B\na\nA\nb\nB\nls\n", 17, ""},
+		{"cat <<A; cat <<B\na\nA\nb\nB\nls\n", 21, ""},
+		{"cat <<A; cat <<B\na\nA\nb\nB\nls\n", 25, "ls"},
+		{"grep x <<< 'a | b' | wc\n", 14, "grep"},
+		{"grep x <<< 'a | b' | wc\n", 21, "wc"},
+	}
+
+	testFind(t, tests)
+
+	_, err := Find("cat <<EOF\nls -l\nEOF\n", 11)
+	if err == nil || err.Error() != "inside heredoc for cat" {
+		t.Error("Expected inside heredoc for cat, got", err)
+	}
+	_, err = Find("cat <<A; wc <<B\na\nA\nb\nB\n", 21)
+	if hdocErr, ok := err.(*HeredocError); !ok || hdocErr.Name != "wc" {
+		t.Error("Expected heredoc for wc, got", err)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []findTest{
+		{"LC_ALL=C sort -u file\n", 2, "sort"},
+		{"LC_ALL=C sort -u file\n", 10, "sort"},
+		{"FOO=1 BAR=2 ./run.sh\n", 0, "./run.sh"},
+		{"x=$(ls -l)\n", 5, "ls"},
+		{"arr=(a $(pwd) c) && ls\n", 9, "pwd"},
+		{"arr=(a $(pwd) c) && ls\n", 20, "ls"},
+		{"a[i+1]=x env\n", 9, "env"},
+		{"echo FOO=1\n", 0, "echo"},
+		{"1x=2 cmd\n", 0, "1x=2"},
+	}
+
+	testFind(t, tests)
+
+	cmd, err := Find("LC_ALL=C sort -u file\n", 10)
+	if err != nil || len(cmd.Env) != 1 || cmd.Env[0] != "LC_ALL=C" || len(cmd.Options) != 1 {
+		t.Error("Expected sort with LC_ALL=C and one option, got", cmd, err)
+	}
+
+	cmd, err = Find("FOO=1 BAR=\"a b\" BAZ+=$(x)\n", 3)
+	if err != nil {
+		t.Fatal("Expected assignments, got", err)
+	}
+	if cmd.Name != "" || len(cmd.Env) != 3 ||
+		cmd.Env[0] != "FOO=1" || cmd.Env[1] != "BAR=a b" || cmd.Env[2] != "BAZ+=$(x)" {
+		t.Error("Expected only assignments, got", cmd.Name, cmd.Env)
+	}
+}
+
+func TestDeclarations(t *testing.T) {
+	tests := []struct {
+		script string
+		name   string
+		args   []string
+	}{
+		{"local arr=(a b)\n", "local", []string{"arr=(a b)"}},
+		{"declare -A m=([a]=1)\n", "declare", []string{"m=([a]=1)"}},
+		{"readonly x=(1)\n", "readonly", []string{"x=(1)"}},
+		{"export PATH=$HOME/bin y\n", "export", []string{"PATH=$HOME/bin", "y"}},
+		{"typeset -a a=() b+=(c)\n", "typeset", []string{"a=()", "b+=(c)"}},
+	}
+
+	for _, test := range tests {
+		cmds, err := FindAll(test.script)
+		if err != nil || len(cmds) != 1 {
+			t.Errorf("%q: expected one command, got %v %v", test.script, cmds, err)
+			continue
+		}
+		cmd := cmds[0]
+		if cmd.Name != test.name || !reflect.DeepEqual(cmd.Args, test.args) {
+			t.Errorf("%q: expected %s %q, got %s %q", test.script, test.name, test.args, cmd.Name, cmd.Args)
+		}
+	}
+
+	testFind(t, []findTest{
+		{"local arr=(a $(pwd) c) && ls\n", 15, "pwd"},
+		{"local arr=(a $(pwd) c) && ls\n", 26, "ls"},
+	})
+}
+
+func TestWrappers(t *testing.T) {
+	tests := []findTest{
+		{"sudo -u root apt-get install -y vim\n", 2, "sudo"},
+		{"sudo -u root apt-get install -y vim\n", 9, "sudo"},
+		{"sudo -u root apt-get install -y vim\n", 13, "apt-get"},
+		{"sudo -u root apt-get install -y vim\n", 30, "apt-get"},
+		{"find . -print0 | xargs -0 -n 2 rm -f\n", 29, "xargs"},
+		{"find . -print0 | xargs -0 -n 2 rm -f\n", 31, "rm"},
+		{"timeout 30 curl -s x\n", 8, "timeout"},
+		{"timeout 30 curl -s x\n", 11, "curl"},
+		{"time ls\n", 5, "ls"},
+		{"exec -a name bash\n", 13, "bash"},
+		{"/usr/bin/sudo ls\n", 14, "ls"},
+		{"xargs\n", 2, "xargs"},
+	}
+
+	testFind(t, tests)
+
+	cmd, err := Find("sudo -u root apt-get install -y vim\n", 13)
+	if err != nil || len(cmd.Wrappers) != 1 || cmd.Wrappers[0].Name != "sudo" ||
+		len(cmd.Wrappers[0].Options) != 1 || cmd.Wrappers[0].Wrapped != cmd {
+		t.Error("Expected apt-get wrapped by sudo -u, got", cmd, err)
+	}
+
+	s := "timeout -s KILL 5s nice -n 10 nohup env -i FOO=1 ./run.sh -v\n"
+	cmd, err = Find(s, 0)
+	if err != nil {
+		t.Fatal("Expected timeout, got", err)
+	}
+	if cmd.Name != "timeout" || len(cmd.Options) != 1 || cmd.Options[0].Flag != "-s" {
+		t.Error("Expected timeout -s, got", cmd.Name, cmd.Options)
+	}
+	inner := cmd.Inner()
+	if inner.Name != "./run.sh" || len(inner.Wrappers) != 4 || len(inner.Env) != 1 || inner.Env[0] != "FOO=1" {
+		t.Error("Expected ./run.sh with 4 wrappers and FOO=1, got", inner.Name, len(inner.Wrappers), inner.Env)
+	}
+	if len(inner.Options) != 1 || inner.Options[0].Flag != "-v" {
+		t.Error("Expected -v, got", inner.Options)
+	}
+}
+
+// globalOptions returns the number of global options of a command.
+func globalOptions(cmd *Command) int {
+	n := 0
+	for _, opt := range cmd.Options {
+		if opt.Global {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestSubcommands(t *testing.T) {
+	tests := []struct {
+		script      string
+		subcommands string
+		options     int
+		global      int
+	}{
+		{"git commit --amend -m x\n", "git commit", 2, 0},
+		{"git -C /tmp push -f\n", "git push", 2, 1},
+		{"docker run -it ubuntu bash\n", "docker run", 1, 0},
+		{"docker container ls -a\n", "docker container ls", 1, 0},
+		{"ip -4 addr show dev eth0\n", "ip address", 1, 1},
+		{"kubectl -n kube-system get pods\n", "kubectl get", 1, 1},
+		{"ls -l dir\n", "ls", 1, 0},
+		{"sudo git status\n", "sudo", 0, 0},
+	}
+
+	for _, test := range tests {
+		cmd, err := Find(test.script, 0)
+		if err != nil {
+			t.Errorf("%q: expected %s, got error %v", test.script, test.subcommands, err)
+		} else if cmd.FullName() != test.subcommands || len(cmd.Options) != test.options {
+			t.Errorf("%q: expected %s with %d options, got %s %v",
+				test.script, test.subcommands, test.options, cmd.FullName(), cmd.Options)
+		} else if global := globalOptions(cmd); global != test.global {
+			t.Errorf("%q: expected %d global options, got %d", test.script, test.global, global)
+		}
+	}
+
+	cmd, _ := Find("sudo git status\n", 6)
+	if cmd == nil || cmd.FullName() != "git status" {
+		t.Error("Expected git status, got", cmd)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		script  string
+		options string
+		args    string
+	}{
+		{"tar -C /tmp -xzf a.tgz\n", "-C /tmp|-xzf a.tgz", ""},
+		{"ls -l dir -a\n", "-l|-a", "dir"},
+		{"sort --key=2 -o out file\n", "--key=2|-o out", "file"},
+		{"grep -e x -- -v file\n", "-e x", "-v|file"},
+		{"git commit -m 'a msg' --amend\n", "-m a msg|--amend", ""},
+		{"sudo -u root ls\n", "-u root", ""},
+		{"timeout 30 ls\n", "", "30"},
+		{"read -r -p 'name? ' -t 5 reply\n", "-r|-p name? |-t 5", "reply"},
+	}
+
+	for _, test := range tests {
+		cmd, err := Find(test.script, 0)
+		if err != nil {
+			t.Errorf("%q: expected options, got error %v", test.script, err)
+			continue
+		}
+
+		options := []string{}
+		for _, opt := range cmd.Options {
+			options = append(options, opt.String())
+		}
+		if strings.Join(options, "|") != test.options || strings.Join(cmd.Args, "|") != test.args {
+			t.Errorf("%q: expected %s and %s, got %v and %v", test.script, test.options, test.args, options, cmd.Args)
+		}
+	}
+
+	cmd, _ := Find("tar -C /tmp -xzf a.tgz\n", 0)
+	if opt := cmd.Options[1]; opt.Start != 12 || opt.End != 22 {
+		t.Error("Expected -xzf a.tgz at 12-22, got", opt.Start, opt.End)
+	}
+
+	takesArg := []struct {
+		script string
+		flag   string
+		takes  bool
+	}{
+		{"tail -n5\n", "-n", true},
+		{"tail -f\n", "-f", false},
+		{"git -C /tmp status\n", "-C", false},
+		{"git commit\n", "-m", true},
+		{"git\n", "-C", true},
+		{"sudo -u root\n", "-u", true},
+		{"foo -x\n", "-x", false},
+	}
+	for _, test := range takesArg {
+		cmd, _ := Find(test.script, 0)
+		if cmd.TakesArg(test.flag) != test.takes {
+			t.Errorf("%q: expected %s to take an argument to be %v", test.script, test.flag, test.takes)
+		}
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	tests := []struct {
+		script    string
+		redirects string
+		args      string
+	}{
+		{"make 2>&1 | less\n", "2>&1", ""},
+		{"ls dir >/dev/null\n", "1>/dev/null", "dir"},
+		{"./build &>>log -v\n", "&>>log", ""},
+		{"sort <input -o out\n", "0<input", ""},
+		{"exec 3<&- 4>>\"$log\"\n", "3<&-|4>>$log", ""},
+		{"cat <<-EOF >out\n\tx\nEOF\n", "0<<-EOF|1>out", ""},
+		{"sudo tee < in > \"$f\" file\n", "0<in|1>$f", ""},
+	}
+
+	for _, test := range tests {
+		cmd, err := Find(test.script, 0)
+		if err != nil {
+			t.Errorf("%q: expected redirects, got error %v", test.script, err)
+			continue
+		}
+
+		redirects := []string{}
+		for _, r := range cmd.Redirects {
+			redirects = append(redirects, r.String())
+		}
+		if strings.Join(redirects, "|") != test.redirects || strings.Join(cmd.Args, "|") != test.args {
+			t.Errorf("%q: expected %s and %s, got %v and %v", test.script, test.redirects, test.args, redirects, cmd.Args)
+		}
+	}
+
+	cmd, _ := Find("sort <in >out 2>&1\n", 0)
+	reads, writes := []bool{true, false, false}, []bool{false, true, false}
+	for i, r := range cmd.Redirects {
+		if r.Reads() != reads[i] || r.Writes() != writes[i] {
+			t.Errorf("%s: expected reads %v and writes %v", r, reads[i], writes[i])
+		}
+	}
+	if r := cmd.Redirects[2]; r.Start != 14 || r.End != 18 {
+		t.Error("Expected 2>&1 at 14-18, got", r.Start, r.End)
+	}
+	if explain := cmd.Redirects[2].Explain(); explain != "standard error is a copy of standard output" {
+		t.Error("Expected standard error to be a copy of standard output, got", explain)
+	}
+
+	explains := []struct {
+		redirect Redirection
+		explain  string
+	}{
+		{Redirection{Fd: 1, Op: "&>>", Target: "log"}, "standard output and standard error are appended to log"},
+		{Redirection{Fd: 1, Op: ">>", Target: "log"}, "standard output is appended to log"},
+		{Redirection{Fd: 2, Op: ">&"}, "standard error has no target"},
+		{Redirection{Fd: 0, Op: "<&"}, "standard input has no target"},
+		{Redirection{Fd: 3, Op: "<&", Target: "-"}, "file descriptor 3 is closed"},
+	}
+	for _, test := range explains {
+		if explain := test.redirect.Explain(); explain != test.explain {
+			t.Errorf("%s: expected %q, got %q", test.redirect, test.explain, explain)
+		}
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	s := "ls -l dir | grep -v x\ncat <<EOF; git -C repo commit -m msg \\\n  file\n$(pwd)\nEOF\necho `date +%s`\n"
+
+	all, err := FindAll(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	tests := []struct {
+		name       string
+		start, end int
+		line       int
+	}{
+		{"ls", 0, 5, 1},
+		{"grep", 12, 19, 1},
+		{"cat", 22, 25, 2},
+		{"git", 33, 58, 2},
+		{"pwd", 70, 73, 4},
+		{"echo", 79, 83, 6},
+		{"date", 85, 89, 6},
+	}
+	if len(all) != len(tests) {
+		t.Fatal("Expected", len(tests), "commands, got", len(all))
+	}
+	for i, test := range tests {
+		cmd := all[i]
+		if cmd.Name != test.name || cmd.Start != test.start || cmd.End != test.end || cmd.Line != test.line {
+			t.Errorf("Expected %s at %d-%d on line %d, got %s at %d-%d on line %d", test.name, test.start,
+				test.end, test.line, cmd.Name, cmd.Start, cmd.End, cmd.Line)
+		}
+	}
+
+	if all[0].Pipeline != all[1].Pipeline || len(all[0].Pipeline.Cmds) != 2 {
+		t.Error("Expected ls and grep in the same pipeline")
+	}
+	if all[2].Pipeline == all[3].Pipeline {
+		t.Error("Expected cat and git in different pipelines")
+	}
+
+	all, err = FindAll("sudo -u root ls -a; echo $(ls\n")
+	if err == nil {
+		t.Error("Expected syntax error")
+	}
+	if len(all) != 3 || all[0].Name != "sudo" || all[0].Wrapped.Name != "ls" || all[0].Wrapped.End != 18 {
+		t.Error("Expected sudo running ls, echo and ls")
+	}
+
+	all, _ = FindAll(strings.Repeat("echo a\n\n", 5000))
+	if len(all) != 5000 {
+		t.Fatal("Expected 5000 commands, got", len(all))
+	}
+	for i, cmd := range all {
+		if cmd.Line != 2*i+1 {
+			t.Fatalf("Expected command %d on line %d, got %d", i, 2*i+1, cmd.Line)
+		}
+	}
+}
+
+func TestReservedWords(t *testing.T) {
+	tests := []findTest{
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 0, "if"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 3, "grep"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 20, "if"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 25, "rm"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 34, "if"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 39, "ls"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 57, "echo"},
+		{"if grep -q x file; then rm file; elif ls; then :; else echo; fi\n", 63, "if"},
+		{"while read -r line; do\n  echo \"$line\"\ndone < file\n", 2, "while"},
+		{"while read -r line; do\n  echo \"$line\"\ndone < file\n", 7, "read"},
+		{"while read -r line; do\n  echo \"$line\"\ndone < file\n", 20, "while"},
+		{"while read -r line; do\n  echo \"$line\"\ndone < file\n", 26, "echo"},
+		{"while read -r line; do\n  echo \"$line\"\ndone < file\n", 40, "while"},
+		{"until false; do :; done\n", 0, "until"},
+		{"for f in *.txt $(ls); do wc \"$f\"; done\n", 1, "for"},
+		{"for f in *.txt $(ls); do wc \"$f\"; done\n", 6, "for"},
+		{"for f in *.txt $(ls); do wc \"$f\"; done\n", 17, "ls"},
+		{"for f in *.txt $(ls); do wc \"$f\"; done\n", 25, "wc"},
+		{"for ((i = 0; i < $(nproc); i++)); do make; done\n", 8, "for"},
+		{"for ((i = 0; i < $(nproc); i++)); do make; done\n", 20, "nproc"},
+		{"for ((i = 0; i < $(nproc); i++)); do make; done\n", 38, "make"},
+		{"case $1 in\n  start|go) run start ;;\n  *) usage ;&\nesac\n", 2, "case"},
+		{"case $1 in\n  start|go) run start ;;\n  *) usage ;&\nesac\n", 8, "case"},
+		{"case $1 in\n  start|go) run start ;;\n  *) usage ;&\nesac\n", 15, ""},
+		{"case $1 in\n  start|go) run start ;;\n  *) usage ;&\nesac\n", 22, "run"},
+		{"case $1 in\n  start|go) run start ;;\n  *) usage ;&\nesac\n", 41, "usage"},
+		{"case $1 in\n  start|go) run start ;;\n  *) usage ;&\nesac\n", 51, "case"},
+		{"function build { make all; }\n", 3, "function"},
+		{"function build { make all; }\n", 15, "{"},
+		{"function build { make all; }\n", 18, "make"},
+		{"build() {\n  make all\n}\n", 0, ""},
+		{"build() {\n  make all\n}\n", 13, "make"},
+		{"[[ -f $(which ls) && $x == y ]] && echo\n", 1, "[["},
+		{"[[ -f $(which ls) && $x == y ]] && echo\n", 20, "[["},
+		{"[[ -f $(which ls) && $x == y ]] && echo\n", 9, "which"},
+		{"[[ -f $(which ls) && $x == y ]] && echo\n", 36, "echo"},
+		{"(( n = $(wc -l < f) * 2 )) || exit\n", 3, "(("},
+		{"(( n = $(wc -l < f) * 2 )) || exit\n", 9, "wc"},
+		{"(( n = $(wc -l < f) * 2 )) || exit\n", 30, "exit"},
+		{"! grep -q x file\n", 0, "!"},
+		{"! grep -q x file\n", 3, "grep"},
+		{"coproc ls -l\n", 2, "coproc"},
+		{"coproc ls -l\n", 7, "ls"},
+		{"coproc ls -l\n", 10, "ls"},
+		{"coproc LS { ls -l; }\n", 7, "coproc"},
+		{"coproc LS { ls -l; }\n", 12, "ls"},
+		{"coproc cat file; echo\n", 7, "cat"},
+		{"coproc while read l; do echo; done\n", 8, "while"},
+		{"coproc while read l; do echo; done\n", 13, "read"},
+		{"echo if then fi\n", 6, "echo"},
+	}
+
+	testFind(t, tests)
+
+	cmd, err := Find("if ls; then :; fi\n", 16)
+	if err != nil || cmd.Kind != Reserved || cmd.Start != 15 || cmd.End != 17 {
+		t.Error("Expected reserved word fi at 15-17, got", cmd, err)
+	}
+	if cmd, _ := Find("if ls; then :; fi\n", 4); cmd.Kind != Simple {
+		t.Error("Expected simple command, got", cmd.Kind)
+	}
+}
+
+func TestSubstitutionKinds(t *testing.T) {
+	tests := []findTest{
+		{"diff <(sort a) >(tee b)\n", 2, "diff"},
+		{"diff <(sort a) >(tee b)\n", 6, ""},
+		{"diff <(sort a) >(tee b)\n", 8, "sort"},
+		{"diff <(sort a) >(tee b)\n", 17, "tee"},
+		{"diff <(sort a) >(tee b)\n", 23, "diff"},
+		{"while read l; do :; done < <(ls -l)\n", 32, "ls"},
+		{"while read l; do :; done < <(ls -l)\n", 26, ""},
+		{"echo $(( x + 1 )) y\n", 9, "echo"},
+		{"echo $(( x + 1 )) y\n", 6, ""},
+		{"echo $(( x + 1 )) y\n", 18, "echo"},
+		{"echo $(( $(wc -l < f) * 2 ))\n", 11, "wc"},
+		{"echo \"$(( 1 + $(id -u) ))\"\n", 17, "id"},
+		{"( cd dir && make ) | tee log\n", 3, "cd"},
+		{"( cd dir && make ) | tee log\n", 13, "make"},
+		{"( cd dir && make ) | tee log\n", 22, "tee"},
+		{"{ a; b; } > out\n", 2, "a"},
+		{"{ a; b; } > out\n", 5, "b"},
+		{"{ a; b; } > out\n", 8, "{"},
+		{"x=$(( (1 + 2) * 3 )); ls\n", 23, "ls"},
+	}
+
+	testFind(t, tests)
+}
+
+func TestFindErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		offset int
+		err    error
+		pos    Pos
+	}{
+		{"echo `", 6, ErrUnterminatedSubstitution, 5},
+		{"ls\necho $(\n", 10, ErrUnterminatedSubstitution, 8},
+		{"ls |\n", 4, ErrMissingCommand, 5},
+		{"; ls\n", 0, ErrUnexpected, 0},
+		{"( ls\n", 0, ErrUnterminatedSubshell, 5},
+		{"if ls; then\n", 12, ErrUnterminatedCompound, 12},
+		{"diff <( \n", 7, ErrUnterminatedSubstitution, 5},
+	}
+
+	for _, test := range tests {
+		_, err := Find(test.script, test.offset)
+		var syntaxErr *Error
+		if !errors.Is(err, test.err) || !errors.As(err, &syntaxErr) || syntaxErr.Pos != test.pos {
+			t.Errorf("%q at %d: expected %v at %d, got %v", test.script, test.offset, test.err, test.pos, err)
+		}
+	}
+
+	if _, err := Find("ls\n\npwd\n", 3); err != ErrNoCommand {
+		t.Error("Expected no command, got", err)
+	}
+	if _, err := Find("", 0); err != ErrEmptyScript {
+		t.Error("Expected empty script, got", err)
+	}
+}
+
+func FuzzFind(f *testing.F) {
+	for _, script := range []string{
+		"ls|\\\n m\\\nv||echo \\\nhello\n",
+		"# ls\nmv #; ls \\\ngrep\n",
+		"ls |mv|| grep|&chown &&pwd;\n",
+		"echo `mv \\`ls \\\\\\`cd \\\\\\\\\\\\\\`gzip hello\\\\\\\\\\\\\\`\\\\\\`\\``\n",
+		"echo $(ls $(mv `grep`))\n",
+		"echo ) \\` | ls\n",
+		"echo 'hello `grep` $(pwd) \\ #'\n",
+		"echo \"$(date | tr a b)\" x\n",
+		"echo \"`ls \\\"a\\\"`\" | wc\n",
+		"echo \"unterminated ; ls\n",
+		"echo a\\;b\\|c | wc\n",
+		"cat <<-'EOF' | grep x\n\tls $(pwd)\n\tEOF\nmv\n",
+		"cat <<A; wc <<B\na\nA\nb\nB\nls\n",
+		"grep x <<< 'a | b' | wc\n",
+		"FOO=1 BAR=\"a b\" BAZ+=$(x)\n",
+		"arr=(a $(pwd) c) && ls\n",
+		"a[i+1]=x env\n",
+		"find . -print0 | xargs -0 -n 2 rm -f\n",
+		"sudo -u root apt-get install -y vim\n",
+		"timeout 30 curl -s x\n",
+		"exec -a name bash\n",
+		"sudo git status\n",
+		"tar -C /tmp -xzf a.tgz\n",
+		"( cd dir && make ) >log; { ls; pwd; } 2>&1\n",
+		"sudo -u root ls -a; echo $(ls\n",
+		"echo `",
+		"if [[ -n $x ]]; then for f in $(ls); do case $f in a|b) (( n++ )) ;; esac; done; fi\n",
+		"f() { ! while read l; do :; done; }\n",
+		"diff <(sort a) >(tee b) < <(ls) $(( (1 + 2) * $(nproc) ))\n",
+	} {
+		f.Add(script, len(script)/2)
+	}
+
+	f.Fuzz(func(t *testing.T, script string, offset int) {
+		if offset < 0 || offset > len(script) {
+			return
+		}
+
+		cmd, err := Find(script, offset)
+		var syntaxErr *Error
+		var hdocErr *HeredocError
+		switch {
+		case err == nil && cmd == nil:
+			t.Error("Expected command or error")
+		case err == nil, err == ErrEmptyScript, err == ErrNoCommand, errors.As(err, &hdocErr):
+		case !errors.As(err, &syntaxErr) || syntaxErr.Err == nil:
+			t.Errorf("Expected typed error, got %#v", err)
+		}
+
+		FindAll(script)
+
+		// Editing the script must give the same command as parsing it afresh
+		edited := &Script{}
+		edited.Update(script[:offset/2] + script[offset:])
+		edited.Update(script)
+		editedCmd, editedErr := edited.Find(offset)
+		if !reflect.DeepEqual(editedCmd, cmd) || fmt.Sprint(editedErr) != fmt.Sprint(err) {
+			t.Errorf("Expected %v, %v after edit, got %v, %v", cmd, err, editedCmd, editedErr)
+		}
+	})
+}
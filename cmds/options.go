@@ -0,0 +1,146 @@
+package cmds
+
+import (
+	"path"
+	"strings"
+)
+
+// Option is an option given to a command, along with its value if it has one.
+type Option struct {
+	Flag       string
+	Value      string
+	Start, End int  // byte range of the option and its value in the script
+	Global     bool // given before the subcommands, so it is an option of the command itself
+}
+
+// optSpec describes which options of a command take an argument.
+type optSpec struct {
+	argOpts     string   // short options that take an argument
+	argLongOpts []string // long options that take an argument
+}
+
+// knownOpts lists the options that take an argument for common commands,
+// by the name of the command and its subcommands.
+var knownOpts = map[string]optSpec{
+	"cp": {"St", []string{"--suffix", "--target-directory"}},
+	"curl": {"AbcdeEFHKmoQrTuUwxXYyz", []string{"--connect-timeout", "--cookie", "--data", "--data-binary",
+		"--data-raw", "--form", "--header", "--max-time", "--output", "--proxy", "--request", "--retry",
+		"--upload-file", "--user", "--user-agent", "--write-out"}},
+	"cut": {"bcdf", []string{"--bytes", "--characters", "--delimiter", "--fields", "--output-delimiter"}},
+	"docker run": {"ehlpuvw", []string{"--entrypoint", "--env", "--env-file", "--hostname", "--label",
+		"--mount", "--name", "--network", "--publish", "--user", "--volume", "--workdir"}},
+	"git commit": {"CcFmt", []string{"--author", "--date", "--file", "--message", "--reuse-message",
+		"--template"}},
+	"grep": {"ABCdDefm", []string{"--after-context", "--before-context", "--context", "--exclude", "--file",
+		"--include", "--max-count", "--regexp"}},
+	"head":      {"cn", []string{"--bytes", "--lines"}},
+	"ln":        {"St", []string{"--suffix", "--target-directory"}},
+	"mapfile":   {"CcdnOsu", nil},
+	"mkdir":     {"m", []string{"--mode"}},
+	"mv":        {"St", []string{"--suffix", "--target-directory"}},
+	"printf":    {"v", nil},
+	"read":      {"adinNptu", nil},
+	"readarray": {"CcdnOsu", nil},
+	"rsync": {"efT", []string{"--exclude", "--exclude-from", "--filter", "--include", "--include-from",
+		"--rsh", "--temp-dir"}},
+	"sed": {"efl", []string{"--expression", "--file", "--line-length"}},
+	"sort": {"koStT", []string{"--buffer-size", "--field-separator", "--key", "--output",
+		"--temporary-directory"}},
+	"ssh":  {"BbcDEeFIiJLlmOoPpQRSWw", nil},
+	"tail": {"cn", []string{"--bytes", "--lines", "--pid"}},
+	"tar": {"bCfFgHIKLNTVX", []string{"--directory", "--exclude", "--exclude-from", "--file", "--files-from",
+		"--format", "--group", "--owner", "--use-compress-program"}},
+	"wget": {"aDOoPtTU", []string{"--directory-prefix", "--domains", "--output-document", "--output-file",
+		"--timeout", "--tries", "--user-agent"}},
+}
+
+// takesArg reports whether the option is followed by an argument.
+func (o optSpec) takesArg(opt string) bool {
+	for _, longOpt := range o.argLongOpts {
+		if opt == longOpt {
+			return true
+		}
+	}
+	if strings.HasPrefix(opt, "--") {
+		return false
+	}
+
+	// Only the last option in a group like -xzf can take the next argument
+	return len(opt) > 1 && strings.IndexByte(o.argOpts, opt[len(opt)-1]) >= 0
+}
+
+// TakesArg reports whether a flag of the command is known to take an
+// argument, which for a short flag can be attached to it, as in -n5.
+func (cmd *Command) TakesArg(flag string) bool {
+	name := path.Base(cmd.Name)
+	if w, ok := wrappers[name]; ok {
+		return w.takesArg(flag)
+	}
+	if s, ok := subcommanders[name]; ok && len(cmd.Subcommands) == 0 {
+		return s.takesArg(flag)
+	}
+
+	return knownOpts[strings.Join(append([]string{name}, cmd.Subcommands...), " ")].takesArg(flag)
+}
+
+// option parses the option in words[0], taking its value from words[1] if it
+// needs one, and returns it with the number of words used.
+func (o optSpec) option(words []*Word) (Option, int) {
+	word := words[0].Lit()
+	opt := Option{Flag: word, Start: int(words[0].Pos()), End: int(words[0].End())}
+
+	if eq := strings.IndexByte(word, '='); strings.HasPrefix(word, "--") && eq > 0 {
+		opt.Flag, opt.Value = word[:eq], word[eq+1:]
+		return opt, 1
+	}
+	if o.takesArg(word) && len(words) > 1 {
+		opt.Value = words[1].Lit()
+		opt.End = int(words[1].End())
+		return opt, 2
+	}
+
+	return opt, 1
+}
+
+// parseOptions splits the arguments of a command into options and positional
+// arguments. Options can come after positional arguments, up to a "--".
+func (o optSpec) parseOptions(words []*Word) ([]Option, []string) {
+	var opts []Option
+	var args []string
+
+	for i := 0; i < len(words); {
+		word := words[i].Lit()
+		switch {
+		case word == "--":
+			for _, word := range words[i+1:] {
+				args = append(args, word.Lit())
+			}
+			return opts, args
+		case isOption(word):
+			opt, n := o.option(words[i:])
+			opts = append(opts, opt)
+			i += n
+		default:
+			args = append(args, word)
+			i++
+		}
+	}
+
+	return opts, args
+}
+
+// String returns the option as it is given to the command.
+func (opt Option) String() string {
+	switch {
+	case opt.Value == "":
+		return opt.Flag
+	case strings.HasPrefix(opt.Flag, "--"):
+		return opt.Flag + "=" + opt.Value
+	}
+
+	return opt.Flag + " " + opt.Value
+}
+
+func isOption(word string) bool {
+	return len(word) > 1 && word[0] == '-'
+}
@@ -0,0 +1,1355 @@
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of syntax errors, which can be checked for with errors.Is.
+var (
+	ErrUnexpected               = errors.New("unexpected token")
+	ErrMissingCommand           = errors.New("missing command")
+	ErrMissingWord              = errors.New("missing word")
+	ErrUnterminatedQuote        = errors.New("unterminated quote")
+	ErrUnterminatedSubstitution = errors.New("unterminated command substitution")
+	ErrUnterminatedSubshell     = errors.New("unterminated subshell")
+	ErrUnterminatedBlock        = errors.New("unterminated block")
+	ErrUnterminatedArray        = errors.New("unterminated array")
+	ErrUnterminatedHeredoc      = errors.New("unterminated heredoc")
+	ErrUnterminatedCompound     = errors.New("unterminated compound command")
+	ErrUnterminatedArithmetic   = errors.New("unterminated arithmetic expression")
+)
+
+// Error is a syntax error found while parsing a script.
+type Error struct {
+	Pos Pos
+	Msg string
+	Err error // kind of error, one of the Err variables
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Pos, e.Msg)
+}
+
+// Unwrap returns the kind of error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// parser holds data for parsing a bash script. Backquoted command
+// substitutions are parsed by a child parser over their unescaped text, in
+// which case starts and ends map every byte of src back to the script.
+type parser struct {
+	src, root    string
+	starts, ends []Pos
+	eof          Pos
+	i, parens    int
+	heredocs     []*Redirect // heredocs whose bodies start after the next newline
+	bodiesEnd    int         // end of the last heredoc bodies
+	comments     []*Comment
+	lines        []int // top-level lines that the rest of the source can be parsed from
+	errs         []*Error
+	err          error // first error
+}
+
+// Contexts in which the parts of a word are parsed.
+const (
+	unquoted = iota
+	dblQuotes
+	heredoc
+)
+
+// Reserved words that end a part of a compound command.
+var terminators = []string{"then", "elif", "else", "fi", "do", "done", "esac"}
+
+var redirectOps = []string{"&>>", "&>", "<<<", "<<-", "<<", "<>", "<&", ">>", ">&", ">|", "<", ">"}
+
+// Parse parses a bash script into its syntax tree. If the script has syntax
+// errors, the first one is returned along with the tree for as much of the
+// script as could be parsed.
+func Parse(script string) (*File, error) {
+	p := &parser{src: script, root: script, eof: Pos(len(script))}
+	list := p.list(0)
+
+	return &File{List: list, Comments: p.comments}, p.err
+}
+
+// list parses and-or lists until the end of the source, the closing
+// parenthesis of the enclosing construct or one of the stop words, which are
+// reserved words or case item terminators.
+func (p *parser) list(closer byte, stops ...string) *List {
+	list := &List{}
+	top := closer == 0 && len(stops) == 0 && p.starts == nil
+	end := p.i // end of the last and-or list
+
+	for {
+		p.skipSpace()
+		if top {
+			p.topLines(end)
+		}
+		if p.i >= len(p.src) {
+			break
+		}
+		if p.src[p.i] == ')' && (closer == ')' || p.parens > 0) || p.stop(stops) != "" {
+			break
+		}
+		if word := p.stop(terminators); word != "" {
+			p.error(p.i, ErrUnexpected, "unexpected "+word)
+			p.i += len(word)
+			end = p.i
+			continue
+		}
+
+		andOr := p.andOr()
+		if andOr == nil {
+			// Operator with no command before it
+			op := p.op(";;", ";", "&&", "&", "||", "|&", "|")
+			if op == "" {
+				op = p.src[p.i : p.i+1]
+			}
+			p.error(p.i, ErrUnexpected, "unexpected "+op)
+			p.i += len(op)
+			end = p.i
+			continue
+		}
+		list.Items = append(list.Items, andOr)
+		end = p.i
+	}
+
+	return list
+}
+
+// topLines records the starts of the lines between the end of the last
+// and-or list and the current position, from which the rest of the source
+// parses the same on its own.
+func (p *parser) topLines(end int) {
+	for i := end; i < p.i; i++ {
+		if p.src[i] != '\n' || i+1 < p.bodiesEnd {
+			continue
+		}
+		// A backslash before the newline could be a line continuation
+		if i > 0 && p.src[i-1] == '\\' {
+			continue
+		}
+		p.lines = append(p.lines, i+1)
+	}
+}
+
+func (p *parser) andOr() *AndOr {
+	pipeline := p.pipeline()
+	if pipeline == nil {
+		return nil
+	}
+	andOr := &AndOr{Pipelines: []*Pipeline{pipeline}}
+
+	for {
+		p.skipBlanks()
+		op := p.op("&&", "||")
+		if op == "" {
+			break
+		}
+		tok := p.token(p.i, p.i+len(op))
+		p.i += len(op)
+
+		p.skipSpace()
+		pipeline := p.pipeline()
+		if pipeline == nil {
+			p.error(p.i, ErrMissingCommand, "expected command after "+op)
+			break
+		}
+		andOr.Ops = append(andOr.Ops, tok)
+		andOr.Pipelines = append(andOr.Pipelines, pipeline)
+	}
+
+	p.skipBlanks()
+	if op := p.op(";", "&"); op != "" && p.op(";;", ";&") == "" {
+		andOr.Sep = p.token(p.i, p.i+1)
+		p.i++
+	}
+
+	return andOr
+}
+
+func (p *parser) pipeline() *Pipeline {
+	pipeline := &Pipeline{}
+	p.skipBlanks()
+	start := p.i
+	if p.reserved("!") {
+		pipeline.Bang = p.keyword("!")
+	}
+
+	cmd := p.command()
+	if cmd == nil && pipeline.Bang.Value != "" {
+		// A lone '!' is a command name
+		p.i, pipeline.Bang = start, Token{}
+		cmd = p.command()
+	}
+	if cmd == nil {
+		return nil
+	}
+	pipeline.Cmds = []Cmd{cmd}
+
+	for {
+		p.skipBlanks()
+		op := p.op("||", "|&", "|")
+		if op == "" || op == "||" {
+			break
+		}
+		tok := p.token(p.i, p.i+len(op))
+		p.i += len(op)
+
+		p.skipSpace()
+		cmd := p.command()
+		if cmd == nil {
+			p.error(p.i, ErrMissingCommand, "expected command after "+op)
+			break
+		}
+		pipeline.Ops = append(pipeline.Ops, tok)
+		pipeline.Cmds = append(pipeline.Cmds, cmd)
+	}
+
+	return pipeline
+}
+
+func (p *parser) command() Cmd {
+	p.skipBlanks()
+
+	switch {
+	case p.hasPrefix("(("):
+		return p.arithmCmd()
+	case p.i < len(p.src) && p.src[p.i] == '(':
+		return p.subshell()
+	case p.reserved("{"):
+		return p.block()
+	case p.reserved("if"):
+		return p.ifClause()
+	case p.reserved("while"), p.reserved("until"):
+		return p.whileClause()
+	case p.reserved("for"), p.reserved("select"):
+		return p.forClause()
+	case p.reserved("case"):
+		return p.caseClause()
+	case p.reserved("[["):
+		return p.testClause()
+	case p.reserved("function"):
+		return p.funcDecl()
+	case p.reserved("coproc"):
+		return p.coproc()
+	case p.funcName() >= 0:
+		return p.funcDecl()
+	}
+
+	return p.simpleCommand()
+}
+
+func (p *parser) simpleCommand() Cmd {
+	cmd := &SimpleCommand{}
+
+	for {
+		p.skipBlanks()
+		if p.i >= len(p.src) {
+			break
+		}
+		if redirect := p.redirect(); redirect != nil {
+			cmd.Redirects = append(cmd.Redirects, redirect)
+			continue
+		}
+		// Assignments are only recognised before the command name
+		if len(cmd.Words) == 0 {
+			if assign := p.assign(); assign != nil {
+				cmd.Assigns = append(cmd.Assigns, assign)
+				continue
+			}
+		} else if declarations[cmd.Words[0].Lit()] {
+			// Declaration builtins take assignments, arrays included, as arguments
+			if assign := p.assign(); assign != nil {
+				cmd.Words = append(cmd.Words, assignWord(assign))
+				continue
+			}
+		}
+
+		c := p.src[p.i]
+		if c == '(' || c == ')' && p.parens == 0 {
+			// Parenthesis that can't start or end anything, kept as a word
+			p.error(p.i, ErrUnexpected, "unexpected "+string(c))
+			lit := &Lit{Value: string(c), ValuePos: p.at(p.i), ValueEnd: p.after(p.i + 1)}
+			cmd.Words = append(cmd.Words, &Word{Parts: []WordPart{lit}})
+			p.i++
+			continue
+		}
+		if (c == '#' || isMeta(c)) && !p.procSubstStart() {
+			break
+		}
+
+		cmd.Words = append(cmd.Words, p.word())
+	}
+
+	if len(cmd.Assigns) == 0 && len(cmd.Words) == 0 && len(cmd.Redirects) == 0 {
+		return nil
+	}
+
+	return cmd
+}
+
+// declarations are the builtins whose arguments can be assignments.
+var declarations = map[string]bool{
+	"declare":  true,
+	"export":   true,
+	"local":    true,
+	"readonly": true,
+	"typeset":  true,
+}
+
+// assignWord returns an assignment given as an argument as a single word,
+// with the elements of an array kept as parts between its parentheses.
+func assignWord(assign *Assign) *Word {
+	name := &Lit{
+		Value:    assign.Name.Value + assign.Op.Value,
+		ValuePos: assign.Name.ValuePos,
+		ValueEnd: assign.Op.ValueEnd,
+	}
+	parts := []WordPart{name}
+	switch {
+	case assign.Value != nil:
+		parts = append(parts, assign.Value.Parts...)
+	case assign.Array != nil:
+		array := assign.Array
+		parts = append(parts, &Lit{Value: "(", ValuePos: array.Lparen.ValuePos, ValueEnd: array.Lparen.ValueEnd})
+		for i, elem := range array.Elems {
+			if i > 0 {
+				prev := array.Elems[i-1].End()
+				parts = append(parts, &Lit{Value: " ", ValuePos: prev, ValueEnd: elem.Pos()})
+			}
+			parts = append(parts, elem.Parts...)
+		}
+		parts = append(parts, &Lit{Value: ")", ValuePos: array.Rparen.ValuePos, ValueEnd: array.Rparen.ValueEnd})
+	}
+	return &Word{Parts: parts}
+}
+
+func (p *parser) coproc() Cmd {
+	coproc := &Coproc{Coproc: p.keyword("coproc")}
+	p.skipBlanks()
+
+	// A word before a compound command names the coprocess
+	j := p.i
+	for j < len(p.src) && isNameByte(p.src[j]) {
+		j++
+	}
+	if j > p.i && j < len(p.src) && (p.src[j] == ' ' || p.src[j] == '\t') && p.src[p.i] > '9' {
+		start := p.i
+		p.i = j
+		p.skipBlanks()
+		if p.compoundStart() {
+			coproc.Name = &Lit{Value: p.src[start:j], ValuePos: p.at(start), ValueEnd: p.after(j)}
+		} else {
+			p.i = start
+		}
+	}
+
+	coproc.Cmd = p.command()
+	if coproc.Cmd == nil {
+		p.error(p.i, ErrMissingCommand, "expected command after coproc")
+	}
+
+	return coproc
+}
+
+// compoundStart reports whether a compound command starts at the current
+// position.
+func (p *parser) compoundStart() bool {
+	if p.i < len(p.src) && p.src[p.i] == '(' {
+		return true
+	}
+	for _, word := range []string{"{", "if", "while", "until", "for", "select", "case", "[["} {
+		if p.reserved(word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) subshell() Cmd {
+	subshell := &Subshell{Lparen: p.token(p.i, p.i+1)}
+	p.i++
+
+	p.parens++
+	subshell.List = p.list(')')
+	p.parens--
+
+	if p.i < len(p.src) && p.src[p.i] == ')' {
+		subshell.Rparen = p.token(p.i, p.i+1)
+		p.i++
+	} else {
+		p.error(p.i, ErrUnterminatedSubshell, "unterminated subshell")
+		subshell.Rparen = p.token(p.i, p.i)
+	}
+	subshell.Redirects = p.redirects()
+
+	return subshell
+}
+
+func (p *parser) block() Cmd {
+	block := &Block{Lbrace: p.token(p.i, p.i+1)}
+	p.i++
+
+	block.List = p.list(0, "}")
+
+	if p.reserved("}") {
+		block.Rbrace = p.token(p.i, p.i+1)
+		p.i++
+	} else {
+		p.error(p.i, ErrUnterminatedBlock, "unterminated block")
+		block.Rbrace = p.token(p.i, p.i)
+	}
+	block.Redirects = p.redirects()
+
+	return block
+}
+
+func (p *parser) ifClause() Cmd {
+	clause := &IfClause{If: p.keyword("if")}
+	clause.Cond = p.list(0, "then")
+	clause.Then = p.expect("then")
+	clause.Body = p.list(0, "elif", "else", "fi")
+
+	for p.reserved("elif") {
+		elif := &Elif{Elif: p.keyword("elif")}
+		elif.Cond = p.list(0, "then")
+		elif.Then = p.expect("then")
+		elif.Body = p.list(0, "elif", "else", "fi")
+		clause.Elifs = append(clause.Elifs, elif)
+	}
+	if p.reserved("else") {
+		clause.Else = p.keyword("else")
+		clause.ElseBody = p.list(0, "fi")
+	}
+
+	clause.Fi = p.expect("fi")
+	clause.Redirects = p.redirects()
+
+	return clause
+}
+
+func (p *parser) whileClause() Cmd {
+	word := "while"
+	if p.reserved("until") {
+		word = "until"
+	}
+	clause := &WhileClause{While: p.keyword(word)}
+
+	clause.Cond = p.list(0, "do")
+	clause.Do = p.expect("do")
+	clause.Body = p.list(0, "done")
+	clause.Done = p.expect("done")
+	clause.Redirects = p.redirects()
+
+	return clause
+}
+
+func (p *parser) forClause() Cmd {
+	word := "for"
+	if p.reserved("select") {
+		word = "select"
+	}
+	clause := &ForClause{For: p.keyword(word)}
+
+	p.skipBlanks()
+	if word == "for" && p.hasPrefix("((") {
+		clause.Arithm = p.arithm()
+	} else {
+		j := p.i
+		for j < len(p.src) && isNameByte(p.src[j]) {
+			j++
+		}
+		if j == p.i {
+			p.error(p.i, ErrMissingWord, "expected name after "+word)
+		} else {
+			clause.Name = &Lit{Value: p.src[p.i:j], ValuePos: p.at(p.i), ValueEnd: p.after(j)}
+			p.i = j
+		}
+
+		p.skipSpace()
+		if p.reserved("in") {
+			clause.In = p.keyword("in")
+			for {
+				p.skipBlanks()
+				if p.i >= len(p.src) || p.src[p.i] == '#' || isMeta(p.src[p.i]) {
+					break
+				}
+				clause.Words = append(clause.Words, p.word())
+			}
+		}
+	}
+
+	p.skipBlanks()
+	if p.op(";") != "" && p.op(";;") == "" {
+		p.i++
+	}
+	p.skipSpace()
+	clause.Do = p.expect("do")
+	clause.Body = p.list(0, "done")
+	clause.Done = p.expect("done")
+	clause.Redirects = p.redirects()
+
+	return clause
+}
+
+func (p *parser) caseClause() Cmd {
+	clause := &CaseClause{Case: p.keyword("case")}
+
+	p.skipBlanks()
+	if p.i < len(p.src) && !isMeta(p.src[p.i]) {
+		clause.Word = p.word()
+	} else {
+		p.error(p.i, ErrMissingWord, "expected word after case")
+	}
+	p.skipSpace()
+	clause.In = p.expect("in")
+
+	for {
+		p.skipSpace()
+		if p.i >= len(p.src) || p.reserved("esac") {
+			break
+		}
+
+		item := &CaseItem{}
+		if p.src[p.i] == '(' {
+			p.i++
+		}
+		for {
+			p.skipBlanks()
+			if p.i < len(p.src) && !isMeta(p.src[p.i]) {
+				item.Patterns = append(item.Patterns, p.word())
+				p.skipBlanks()
+			}
+			if p.op("|") == "" || p.op("||") != "" {
+				break
+			}
+			p.i++
+		}
+		if p.i >= len(p.src) || p.src[p.i] != ')' {
+			p.error(p.i, ErrUnterminatedCompound, "expected ) after pattern")
+			break
+		}
+		item.Rparen = p.token(p.i, p.i+1)
+		p.i++
+
+		item.Body = p.list(0, ";;&", ";;", ";&", "esac")
+		if op := p.op(";;&", ";;", ";&"); op != "" {
+			item.Op = p.token(p.i, p.i+len(op))
+			p.i += len(op)
+		}
+		clause.Items = append(clause.Items, item)
+		if item.Op.Value == "" {
+			break
+		}
+	}
+
+	p.skipSpace()
+	clause.Esac = p.expect("esac")
+	clause.Redirects = p.redirects()
+
+	return clause
+}
+
+// testClause parses a conditional command. Its expression is only split into
+// words, with operators as words of their own.
+func (p *parser) testClause() Cmd {
+	start := p.i
+	clause := &TestClause{Left: p.keyword("[[")}
+
+	for {
+		p.skipSpace()
+		switch {
+		case p.i >= len(p.src):
+			p.error(start, ErrUnterminatedCompound, "unterminated [[")
+			clause.Right = p.token(p.i, p.i)
+			return clause
+		case p.reserved("]]"):
+			clause.Right = p.keyword("]]")
+			clause.Redirects = p.redirects()
+			return clause
+		case isMeta(p.src[p.i]):
+			op := p.op("&&", "||")
+			if op == "" {
+				op = p.src[p.i : p.i+1]
+			}
+			lit := &Lit{Value: op, ValuePos: p.at(p.i), ValueEnd: p.after(p.i + len(op))}
+			clause.Words = append(clause.Words, &Word{Parts: []WordPart{lit}})
+			p.i += len(op)
+		default:
+			clause.Words = append(clause.Words, p.word())
+		}
+	}
+}
+
+func (p *parser) arithmCmd() Cmd {
+	arithm := p.arithm()
+	arithm.Redirects = p.redirects()
+
+	return arithm
+}
+
+// arithm parses an expression in double parentheses.
+func (p *parser) arithm() *ArithmCmd {
+	start := p.i
+	arithm := &ArithmCmd{Left: p.token(p.i, p.i+2)}
+	p.i += 2
+	arithm.Parts, arithm.Right = p.arithmExpr(start)
+
+	return arithm
+}
+
+// arithmExpr parses an arithmetic expression, which ends at the first '))'
+// outside of any other parentheses, and returns its parts and the closing
+// token. start is the position of the opening delimiter.
+func (p *parser) arithmExpr(start int) ([]WordPart, Token) {
+	depth, end := 0, -1
+	for j := p.i; j < len(p.src) && end < 0; j++ {
+		switch {
+		case p.src[j] == '(':
+			depth++
+		case p.src[j] == ')' && depth > 0:
+			depth--
+		case p.hasPrefixAt(j, "))"):
+			end = j
+		}
+	}
+	if end < 0 {
+		p.error(start, ErrUnterminatedArithmetic, "unterminated arithmetic expression")
+		end = len(p.src)
+	}
+
+	sub := p.sub(p.i, end)
+	parts := sub.parts(heredoc)
+	p.merge(sub)
+
+	p.i = end
+	if p.i >= len(p.src) {
+		return parts, p.token(p.i, p.i)
+	}
+
+	return parts, p.keyword("))")
+}
+
+// funcDecl parses a function definition, which starts with either the
+// function keyword or the name and '()'.
+func (p *parser) funcDecl() Cmd {
+	decl := &FuncDecl{}
+	if p.reserved("function") {
+		decl.Function = p.keyword("function")
+		p.skipBlanks()
+	}
+
+	j := p.funcNameEnd()
+	if j == p.i {
+		p.error(p.i, ErrMissingWord, "expected function name")
+	}
+	// The parentheses are optional after the function keyword
+	parens := p.funcName() >= 0
+	decl.Name = &Lit{Value: p.src[p.i:j], ValuePos: p.at(p.i), ValueEnd: p.after(j)}
+	p.i = j
+
+	if parens {
+		p.skipBlanks()
+		p.i++
+		p.skipBlanks()
+		p.i++
+	}
+	p.skipSpace()
+
+	decl.Body = p.command()
+	if decl.Body == nil {
+		p.error(p.i, ErrMissingCommand, "expected function body")
+	}
+
+	return decl
+}
+
+func (p *parser) redirects() []*Redirect {
+	var redirects []*Redirect
+
+	for {
+		p.skipBlanks()
+		redirect := p.redirect()
+		if redirect == nil {
+			return redirects
+		}
+		redirects = append(redirects, redirect)
+	}
+}
+
+// redirect parses a redirection if there is one at the current position.
+func (p *parser) redirect() *Redirect {
+	j := p.i
+	for j < len(p.src) && p.src[j] >= '0' && p.src[j] <= '9' {
+		j++
+	}
+
+	op := ""
+	for _, redirectOp := range redirectOps {
+		if strings.HasPrefix(p.src[j:], redirectOp) {
+			op = redirectOp
+			break
+		}
+	}
+	// A number in front of &> is a word, not a file descriptor
+	if op == "" || j > p.i && op[0] == '&' || j == p.i && p.procSubstStart() {
+		return nil
+	}
+
+	redirect := &Redirect{}
+	if j > p.i {
+		redirect.N = &Lit{Value: p.src[p.i:j], ValuePos: p.at(p.i), ValueEnd: p.after(j)}
+	}
+	redirect.Op = p.token(j, j+len(op))
+	p.i = j + len(op)
+
+	p.skipBlanks()
+	if p.i < len(p.src) && p.src[p.i] != '#' && !isMeta(p.src[p.i]) || p.procSubstStart() {
+		redirect.Word = p.word()
+		if op == "<<" || op == "<<-" {
+			p.heredocs = append(p.heredocs, redirect)
+		}
+	} else {
+		p.error(p.i, ErrMissingWord, "expected word after "+op)
+	}
+
+	return redirect
+}
+
+// heredocBodies parses the bodies of the pending heredocs, which start at the
+// current position, right after a newline.
+func (p *parser) heredocBodies() {
+	for _, redirect := range p.heredocs {
+		delim := redirect.Word.Lit()
+		pos, end := redirect.Word.Pos(), redirect.Word.End()
+		// Expansions only happen in the body if no part of the delimiter is quoted
+		quoted := p.root[pos:end] != delim
+
+		start, stop := p.i, -1
+		for p.i < len(p.src) {
+			j := strings.IndexByte(p.src[p.i:], '\n')
+			if j < 0 {
+				j = len(p.src) - p.i
+			}
+			line := p.src[p.i : p.i+j]
+			if redirect.Op.Value == "<<-" {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == delim {
+				stop = p.i
+				p.i += j
+				break
+			}
+			p.i += j
+			if p.i < len(p.src) {
+				p.i++
+			}
+		}
+
+		hdoc := &Heredoc{ValuePos: p.at(start)}
+		if stop < 0 {
+			p.error(start, ErrUnterminatedHeredoc, "unterminated heredoc")
+			stop = p.i
+		} else {
+			hdoc.Delim = p.token(stop, p.i)
+		}
+		hdoc.ValueEnd = p.after(stop)
+
+		if quoted && stop > start {
+			hdoc.Parts = []WordPart{&Lit{Value: p.src[start:stop], ValuePos: p.at(start), ValueEnd: p.after(stop)}}
+		} else if !quoted {
+			sub := p.sub(start, stop)
+			hdoc.Parts = sub.parts(heredoc)
+			p.merge(sub)
+		}
+		redirect.Hdoc = hdoc
+
+		// The next body starts after the newline ending the delimiter
+		if p.i < len(p.src) {
+			p.i++
+		}
+	}
+	p.heredocs = nil
+	p.bodiesEnd = p.i
+}
+
+// assign parses a variable assignment if there is one at the current position.
+func (p *parser) assign() *Assign {
+	j := p.i
+	for j < len(p.src) && isNameByte(p.src[j]) {
+		j++
+	}
+	// Names can't start with a digit
+	if j == p.i || p.src[p.i] <= '9' {
+		return nil
+	}
+	// Array element, such as 'a[i+1]=x'
+	if j < len(p.src) && p.src[j] == '[' {
+		k := strings.IndexByte(p.src[j:], ']')
+		if k < 0 || strings.ContainsAny(p.src[j:j+k], " \t\n") {
+			return nil
+		}
+		j += k + 1
+	}
+
+	op := ""
+	if strings.HasPrefix(p.src[j:], "+=") {
+		op = "+="
+	} else if strings.HasPrefix(p.src[j:], "=") {
+		op = "="
+	} else {
+		return nil
+	}
+
+	assign := &Assign{
+		Name: &Lit{Value: p.src[p.i:j], ValuePos: p.at(p.i), ValueEnd: p.after(j)},
+		Op:   p.token(j, j+len(op)),
+	}
+	p.i = j + len(op)
+
+	switch {
+	case p.i >= len(p.src):
+	case p.src[p.i] == '(':
+		assign.Array = p.array()
+	case !isMeta(p.src[p.i]):
+		assign.Value = p.word()
+	}
+
+	return assign
+}
+
+func (p *parser) array() *Array {
+	start := p.i
+	array := &Array{Lparen: p.token(p.i, p.i+1)}
+	p.i++
+
+	for {
+		p.skipSpace()
+		switch {
+		case p.i >= len(p.src):
+			p.error(start, ErrUnterminatedArray, "unterminated array")
+			array.Rparen = p.token(p.i, p.i)
+			return array
+		case p.src[p.i] == ')':
+			array.Rparen = p.token(p.i, p.i+1)
+			p.i++
+			return array
+		case isMeta(p.src[p.i]):
+			p.error(p.i, ErrUnexpected, "unexpected "+p.src[p.i:p.i+1])
+			p.i++
+		default:
+			array.Elems = append(array.Elems, p.word())
+		}
+	}
+}
+
+func (p *parser) word() *Word {
+	return &Word{Parts: p.parts(unquoted)}
+}
+
+// parts parses the parts of a word, a double quoted string or a heredoc body,
+// depending on the context, up to the first byte that ends it.
+func (p *parser) parts(context int) []WordPart {
+	var parts []WordPart
+	lit, start, end := "", -1, -1
+
+	flush := func() {
+		if start >= 0 {
+			parts = append(parts, &Lit{Value: lit, ValuePos: p.at(start), ValueEnd: p.after(end)})
+		}
+		lit, start, end = "", -1, -1
+	}
+	add := func(value string, n int) {
+		if start < 0 {
+			start = p.i
+		}
+		lit += value
+		p.i += n
+		end = p.i
+	}
+
+	for p.i < len(p.src) {
+		c := p.src[p.i]
+		switch {
+		case p.hasPrefix("\\\n"):
+			p.i += 2
+		case c == '\\' && p.i+1 < len(p.src):
+			// When quoted a backslash only escapes $, `, " and itself
+			if context == dblQuotes && strings.IndexByte("$`\"\\", p.src[p.i+1]) < 0 ||
+				context == heredoc && strings.IndexByte("$`\\", p.src[p.i+1]) < 0 {
+				add("\\", 1)
+			} else {
+				add(p.src[p.i+1:p.i+2], 2)
+			}
+		case context == dblQuotes && c == '"':
+			flush()
+			return parts
+		case context == unquoted && c == '\'':
+			flush()
+			parts = append(parts, p.sglQuoted())
+		case context == unquoted && p.hasPrefix("$'"):
+			flush()
+			parts = append(parts, p.ansiCQuoted())
+		case context == unquoted && c == '"':
+			flush()
+			parts = append(parts, p.dblQuoted())
+		case p.hasPrefix("$(("):
+			flush()
+			parts = append(parts, p.arithmExp())
+		case p.hasPrefix("$("):
+			flush()
+			parts = append(parts, p.cmdSubst())
+		case context == unquoted && start < 0 && len(parts) == 0 && p.procSubstStart():
+			parts = append(parts, p.procSubst())
+		case c == '`':
+			flush()
+			parts = append(parts, p.backquotes(context == dblQuotes))
+		case context == unquoted && isMeta(c):
+			flush()
+			return parts
+		default:
+			add(p.src[p.i:p.i+1], 1)
+		}
+	}
+	flush()
+
+	return parts
+}
+
+func (p *parser) sglQuoted() WordPart {
+	quoted := &SglQuoted{Left: p.token(p.i, p.i+1)}
+	p.i++
+
+	j := strings.IndexByte(p.src[p.i:], '\'')
+	if j < 0 {
+		p.error(p.i-1, ErrUnterminatedQuote, "unterminated single quote")
+		quoted.Value = p.src[p.i:]
+		p.i = len(p.src)
+		quoted.Right = p.token(p.i, p.i)
+		return quoted
+	}
+
+	quoted.Value = p.src[p.i : p.i+j]
+	p.i += j
+	quoted.Right = p.token(p.i, p.i+1)
+	p.i++
+
+	return quoted
+}
+
+// ansiCQuoted parses an ANSI-C quoted string, in which a backslash escapes
+// the quote.
+func (p *parser) ansiCQuoted() WordPart {
+	start := p.i
+	quoted := &SglQuoted{Left: p.token(p.i, p.i+2), Dollar: true}
+	p.i += 2
+
+	j := p.i
+	for j < len(p.src) && p.src[j] != '\'' {
+		if p.src[j] == '\\' {
+			j++
+		}
+		j++
+	}
+	if j >= len(p.src) {
+		p.error(start, ErrUnterminatedQuote, "unterminated ANSI-C quote")
+		quoted.Value = ansiC(p.src[p.i:])
+		p.i = len(p.src)
+		quoted.Right = p.token(p.i, p.i)
+		return quoted
+	}
+
+	quoted.Value = ansiC(p.src[p.i:j])
+	p.i = j
+	quoted.Right = p.token(p.i, p.i+1)
+	p.i++
+
+	return quoted
+}
+
+// ansiCEscapes are the characters of the backslash escapes of ANSI-C quoting
+// that stand for a single character.
+var ansiCEscapes = map[byte]string{
+	'a': "\a", 'b': "\b", 'e': "\x1b", 'E': "\x1b", 'f': "\f", 'n': "\n", 'r': "\r", 't': "\t", 'v': "\v",
+	'\\': "\\", '\'': "'", '"': "\"", '?': "?",
+}
+
+// ansiC replaces the backslash escapes in the text of an ANSI-C quoted string.
+func ansiC(text string) string {
+	var value strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			value.WriteByte(text[i])
+			continue
+		}
+		i++
+		if escape, ok := ansiCEscapes[text[i]]; ok {
+			value.WriteString(escape)
+			continue
+		}
+
+		// Numeric escapes take as many digits as they can, up to a limit
+		base, max := 0, 0
+		switch text[i] {
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			base, max, i = 8, 3, i-1
+		case 'x':
+			base, max = 16, 2
+		case 'u':
+			base, max = 16, 4
+		case 'U':
+			base, max = 16, 8
+		case 'c':
+			if i+1 < len(text) {
+				i++
+				value.WriteByte(text[i] & 0x1f)
+				continue
+			}
+		}
+		n, digits := 0, 0
+		for digits < max && i+1 < len(text) {
+			d, err := strconv.ParseUint(text[i+1:i+2], base, 8)
+			if err != nil {
+				break
+			}
+			n, digits, i = n*base+int(d), digits+1, i+1
+		}
+		switch {
+		case digits == 0:
+			// Unknown escapes are kept as they are
+			value.WriteString(text[i-1 : i+1])
+		case text[i-digits] == 'u' || text[i-digits] == 'U':
+			value.WriteRune(rune(n))
+		default:
+			value.WriteByte(byte(n))
+		}
+	}
+
+	return value.String()
+}
+
+func (p *parser) dblQuoted() WordPart {
+	start := p.i
+	quoted := &DblQuoted{Left: p.token(p.i, p.i+1)}
+	p.i++
+
+	quoted.Parts = p.parts(dblQuotes)
+
+	if p.i < len(p.src) {
+		quoted.Right = p.token(p.i, p.i+1)
+		p.i++
+	} else {
+		p.error(start, ErrUnterminatedQuote, "unterminated double quote")
+		quoted.Right = p.token(p.i, p.i)
+	}
+
+	return quoted
+}
+
+func (p *parser) cmdSubst() WordPart {
+	start := p.i
+	subst := &CmdSubst{Left: p.token(p.i, p.i+2)}
+	p.i += 2
+
+	p.parens++
+	subst.List = p.list(')')
+	p.parens--
+
+	if p.i < len(p.src) && p.src[p.i] == ')' {
+		subst.Right = p.token(p.i, p.i+1)
+		p.i++
+	} else {
+		p.error(start, ErrUnterminatedSubstitution, "unterminated command substitution")
+		subst.Right = p.token(p.i, p.i)
+	}
+
+	return subst
+}
+
+// procSubst parses a process substitution, which is parsed like a command
+// substitution.
+func (p *parser) procSubst() WordPart {
+	start := p.i
+	subst := &ProcSubst{Left: p.token(p.i, p.i+2)}
+	p.i += 2
+
+	p.parens++
+	subst.List = p.list(')')
+	p.parens--
+
+	if p.i < len(p.src) && p.src[p.i] == ')' {
+		subst.Right = p.token(p.i, p.i+1)
+		p.i++
+	} else {
+		p.error(start, ErrUnterminatedSubstitution, "unterminated process substitution")
+		subst.Right = p.token(p.i, p.i)
+	}
+
+	return subst
+}
+
+func (p *parser) arithmExp() WordPart {
+	start := p.i
+	exp := &ArithmExp{Left: p.token(p.i, p.i+3)}
+	p.i += 3
+	exp.Parts, exp.Right = p.arithmExpr(start)
+
+	return exp
+}
+
+// backquotes parses a backquoted command substitution. Its text is unescaped
+// and parsed by a child parser, which handles any level of nesting. In double
+// quotes, escaped double quotes are unescaped as well.
+func (p *parser) backquotes(quoted bool) WordPart {
+	start := p.i
+	subst := &CmdSubst{Left: p.token(p.i, p.i+1), Backquotes: true}
+	p.i++
+
+	child := &parser{root: p.root, starts: []Pos{}, ends: []Pos{}}
+	src := []byte{}
+	for p.i < len(p.src) && p.src[p.i] != '`' {
+		n := 1
+		if p.src[p.i] == '\\' && p.i+1 < len(p.src) {
+			n = 2
+		}
+		c := p.src[p.i+n-1]
+		if n == 2 && c != '$' && c != '`' && c != '\\' && !(quoted && c == '"') {
+			// Backslash keeps its meaning, only the backslash is added now
+			n, c = 1, '\\'
+		}
+
+		src = append(src, c)
+		child.starts = append(child.starts, p.at(p.i))
+		child.ends = append(child.ends, p.after(p.i+n))
+		p.i += n
+	}
+	child.src = string(src)
+	child.eof = p.at(p.i)
+
+	subst.List = child.list(0)
+	p.merge(child)
+
+	if p.i < len(p.src) {
+		subst.Right = p.token(p.i, p.i+1)
+		p.i++
+	} else {
+		p.error(start, ErrUnterminatedSubstitution, "unterminated command substitution")
+		subst.Right = p.token(p.i, p.i)
+	}
+
+	return subst
+}
+
+// skipBlanks skips spaces, tabs and line continuations.
+func (p *parser) skipBlanks() {
+	for p.i < len(p.src) {
+		switch {
+		case p.src[p.i] == ' ' || p.src[p.i] == '\t':
+			p.i++
+		case p.hasPrefix("\\\n"):
+			p.i += 2
+		default:
+			return
+		}
+	}
+}
+
+// skipSpace skips blanks, newlines and comments.
+func (p *parser) skipSpace() {
+	for {
+		p.skipBlanks()
+		switch {
+		case p.i >= len(p.src):
+			return
+		case p.src[p.i] == '\n':
+			p.i++
+			if len(p.heredocs) > 0 {
+				p.heredocBodies()
+			}
+		case p.src[p.i] == '#':
+			j := strings.IndexByte(p.src[p.i:], '\n')
+			if j < 0 {
+				j = len(p.src) - p.i
+			}
+			p.comments = append(p.comments, &Comment{
+				Hash:     p.at(p.i),
+				ValueEnd: p.after(p.i + j),
+				Text:     p.src[p.i+1 : p.i+j],
+			})
+			p.i += j
+		default:
+			return
+		}
+	}
+}
+
+// reserved reports whether the reserved word is at the current position.
+func (p *parser) reserved(word string) bool {
+	if !p.hasPrefix(word) {
+		return false
+	}
+
+	j := p.i + len(word)
+	return j >= len(p.src) || isMeta(p.src[j])
+}
+
+// keyword returns the token for the reserved word at the current position and
+// moves past it.
+func (p *parser) keyword(word string) Token {
+	tok := p.token(p.i, p.i+len(word))
+	p.i += len(word)
+
+	return tok
+}
+
+// expect returns the token for the reserved word that has to come next in a
+// compound command, which is empty if the word is missing.
+func (p *parser) expect(word string) Token {
+	if !p.reserved(word) {
+		p.error(p.i, ErrUnterminatedCompound, "expected "+word)
+		return p.token(p.i, p.i)
+	}
+
+	return p.keyword(word)
+}
+
+// stop returns the first of the stop words of a list that is at the current
+// position.
+func (p *parser) stop(words []string) string {
+	for _, word := range words {
+		if word[0] == ';' && p.hasPrefix(word) || p.reserved(word) {
+			return word
+		}
+	}
+
+	return ""
+}
+
+// funcName returns the end of the name if a function definition in the
+// 'name ()' form is at the current position, and -1 otherwise.
+func (p *parser) funcName() int {
+	j := p.funcNameEnd()
+	if j == p.i {
+		return -1
+	}
+
+	k := j
+	for _, c := range []byte{'(', ')'} {
+		for k < len(p.src) && (p.src[k] == ' ' || p.src[k] == '\t') {
+			k++
+		}
+		if k >= len(p.src) || p.src[k] != c {
+			return -1
+		}
+		k++
+	}
+
+	return j
+}
+
+// funcNameEnd returns the end of the function name at the current position.
+func (p *parser) funcNameEnd() int {
+	j := p.i
+	for j < len(p.src) && !isMeta(p.src[j]) && strings.IndexByte("\"'`$\\=#", p.src[j]) < 0 {
+		j++
+	}
+
+	return j
+}
+
+// op returns the first of the operators that is at the current position.
+func (p *parser) op(ops ...string) string {
+	for _, op := range ops {
+		if p.hasPrefix(op) {
+			return op
+		}
+	}
+
+	return ""
+}
+
+// procSubstStart reports whether a process substitution starts at the
+// current position.
+func (p *parser) procSubstStart() bool {
+	return p.hasPrefix("<(") || p.hasPrefix(">(")
+}
+
+func (p *parser) hasPrefix(s string) bool {
+	return p.hasPrefixAt(p.i, s)
+}
+
+func (p *parser) hasPrefixAt(i int, s string) bool {
+	return strings.HasPrefix(p.src[i:], s)
+}
+
+// sub returns a parser for bytes i through j-1 of the source, starting at i.
+func (p *parser) sub(i, j int) *parser {
+	sub := &parser{src: p.src[:j], root: p.root, eof: p.after(j), i: i}
+	if p.starts != nil {
+		sub.starts, sub.ends = p.starts[:j], p.ends[:j]
+	}
+
+	return sub
+}
+
+// merge merges the comments and errors of a child parser.
+func (p *parser) merge(child *parser) {
+	p.comments = append(p.comments, child.comments...)
+	p.errs = append(p.errs, child.errs...)
+	if child.err != nil && p.err == nil {
+		p.err = child.err
+	}
+}
+
+// error records an error of the given kind at byte i of the source.
+func (p *parser) error(i int, kind error, msg string) {
+	err := &Error{Pos: p.at(i), Msg: msg, Err: kind}
+	p.errs = append(p.errs, err)
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// token creates a token for bytes i through j-1 of the source.
+func (p *parser) token(i, j int) Token {
+	pos, end := p.at(i), p.after(j)
+	return Token{Value: p.root[pos:end], ValuePos: pos, ValueEnd: end}
+}
+
+// at returns the position in the script of byte i of the source.
+func (p *parser) at(i int) Pos {
+	switch {
+	case p.starts == nil:
+		return Pos(i)
+	case i < len(p.starts):
+		return p.starts[i]
+	}
+
+	return p.eof
+}
+
+// after returns the position in the script after the first i bytes of the source.
+func (p *parser) after(i int) Pos {
+	switch {
+	case p.ends == nil:
+		return Pos(i)
+	case i > 0:
+		return p.ends[i-1]
+	}
+
+	return p.at(0)
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isMeta(c byte) bool {
+	return strings.IndexByte(" \t\n;&|<>()", c) >= 0
+}
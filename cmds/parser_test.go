@@ -0,0 +1,287 @@
+package cmds
+
+import "testing"
+
+func TestParseLists(t *testing.T) {
+	s := "ls -l | grep x && echo ok; cd /tmp &\npwd\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	items := file.List.Items
+	if len(items) != 3 {
+		t.Fatal("Expected 3 and-or lists, got", len(items))
+	}
+	if len(items[0].Pipelines) != 2 || items[0].Ops[0].Value != "&&" {
+		t.Error("Expected two pipelines joined by &&")
+	}
+	if items[0].Sep.Value != ";" || items[1].Sep.Value != "&" || items[2].Sep.Value != "" {
+		t.Error("Expected separators ;, & and none")
+	}
+
+	pipeline := items[0].Pipelines[0]
+	if len(pipeline.Cmds) != 2 || pipeline.Ops[0].Value != "|" {
+		t.Fatal("Expected two commands joined by |")
+	}
+	grep := pipeline.Cmds[1].(*SimpleCommand)
+	if grep.Pos() != 8 || grep.End() != 14 {
+		t.Error("Expected grep at 8-14, got", grep.Pos(), grep.End())
+	}
+	if name := grep.Words[0].Lit(); name != "grep" {
+		t.Error("Expected grep, got", name)
+	}
+}
+
+func TestParseCompound(t *testing.T) {
+	s := "( cd dir && make ) >log; { ls; pwd; } 2>&1\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	subshell, ok := file.List.Items[0].Pipelines[0].Cmds[0].(*Subshell)
+	if !ok {
+		t.Fatal("Expected subshell")
+	}
+	if len(subshell.List.Items) != 1 || subshell.End() != 23 {
+		t.Error("Expected subshell with one and-or list ending at 23, got", subshell.End())
+	}
+	if len(subshell.Redirects) != 1 || subshell.Redirects[0].Word.Lit() != "log" {
+		t.Error("Expected redirection to log")
+	}
+
+	block, ok := file.List.Items[1].Pipelines[0].Cmds[0].(*Block)
+	if !ok {
+		t.Fatal("Expected block")
+	}
+	if len(block.List.Items) != 2 {
+		t.Error("Expected block with two and-or lists, got", len(block.List.Items))
+	}
+	redirect := block.Redirects[0]
+	if redirect.N.Value != "2" || redirect.Op.Value != ">&" || redirect.Word.Lit() != "1" {
+		t.Error("Expected 2>&1, got", redirect.N.Value, redirect.Op.Value, redirect.Word.Lit())
+	}
+}
+
+func TestParseCoproc(t *testing.T) {
+	tests := []struct {
+		script string
+		name   string
+		end    Pos
+	}{
+		{"coproc ls -l\n", "", 12},
+		{"coproc LS { ls -l; }\n", "LS", 20},
+		{"coproc LS\n", "", 9},
+		{"coproc (sleep 1)\n", "", 16},
+	}
+
+	for _, test := range tests {
+		file, err := Parse(test.script)
+		if err != nil {
+			t.Errorf("%q: expected no error, got %v", test.script, err)
+			continue
+		}
+		coproc, ok := file.List.Items[0].Pipelines[0].Cmds[0].(*Coproc)
+		if !ok || coproc.Cmd == nil {
+			t.Errorf("%q: expected coproc with a command", test.script)
+			continue
+		}
+		name := ""
+		if coproc.Name != nil {
+			name = coproc.Name.Value
+		}
+		if name != test.name || coproc.End() != test.end {
+			t.Errorf("%q: expected name %q ending at %d, got %q at %d", test.script, test.name, test.end, name, coproc.End())
+		}
+	}
+
+	if _, err := Parse("coproc\n"); err == nil {
+		t.Error("Expected an error for coproc without a command")
+	}
+}
+
+func TestParseWords(t *testing.T) {
+	s := "echo a\\ b'c d'\\\ne $(ls)\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	cmd := file.List.Items[0].Pipelines[0].Cmds[0].(*SimpleCommand)
+	if len(cmd.Words) != 3 {
+		t.Fatal("Expected 3 words, got", len(cmd.Words))
+	}
+	if lit := cmd.Words[1].Lit(); lit != "a bc de" {
+		t.Error("Expected a bc de, got", lit)
+	}
+	if lit := cmd.Words[2].Lit(); lit != "" {
+		t.Error("Expected no literal value, got", lit)
+	}
+	subst := cmd.Words[2].Parts[0].(*CmdSubst)
+	if subst.Pos() != 18 || subst.End() != 23 {
+		t.Error("Expected substitution at 18-23, got", subst.Pos(), subst.End())
+	}
+}
+
+func TestParseBackquotes(t *testing.T) {
+	s := "echo `mv \\`ls\\``\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	echo := file.List.Items[0].Pipelines[0].Cmds[0].(*SimpleCommand)
+	outer := echo.Words[1].Parts[0].(*CmdSubst)
+	if !outer.Backquotes || outer.Pos() != 5 || outer.End() != 16 {
+		t.Error("Expected backquotes at 5-16, got", outer.Pos(), outer.End())
+	}
+
+	mv := outer.List.Items[0].Pipelines[0].Cmds[0].(*SimpleCommand)
+	inner := mv.Words[1].Parts[0].(*CmdSubst)
+	if inner.Left.Value != "\\`" || inner.Pos() != 9 || inner.End() != 15 {
+		t.Error("Expected \\` at 9-15, got", inner.Left.Value, inner.Pos(), inner.End())
+	}
+
+	ls := inner.List.Items[0].Pipelines[0].Cmds[0].(*SimpleCommand)
+	if ls.Pos() != 11 || ls.End() != 13 {
+		t.Error("Expected ls at 11-13, got", ls.Pos(), ls.End())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"echo $(ls\n", "echo 'a\n", "ls | ;\n", "echo )\n", "( ls\n", "diff <(ls\n", "echo $(( 1\n"} {
+		file, err := Parse(s)
+		if err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+		if file == nil || len(file.List.Items) == 0 {
+			t.Errorf("Expected partial tree for %q", s)
+		}
+	}
+}
+
+func TestParseHeredoc(t *testing.T) {
+	s := "cat <<EOF; cat <<'END'\na $(b)\nEOF\n$(c)\nEND\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	first := file.List.Items[0].Pipelines[0].Cmds[0].(*SimpleCommand).Redirects[0].Hdoc
+	if first == nil || len(first.Parts) != 3 || first.Delim.Value != "EOF" {
+		t.Fatal("Expected heredoc with literals and substitution ending at EOF")
+	}
+	if first.Pos() != 23 || first.End() != 33 {
+		t.Error("Expected heredoc at 23-33, got", first.Pos(), first.End())
+	}
+
+	second := file.List.Items[1].Pipelines[0].Cmds[0].(*SimpleCommand).Redirects[0].Hdoc
+	if second == nil || len(second.Parts) != 1 || second.Parts[0].(*Lit).Value != "$(c)\n" {
+		t.Error("Expected quoted heredoc with literal body")
+	}
+
+	if _, err := Parse("cat <<EOF\nno end\n"); err == nil {
+		t.Error("Expected unterminated heredoc error")
+	}
+}
+
+func TestParseReserved(t *testing.T) {
+	s := "if a; then b; elif c; then d; else e; fi >log\n" +
+		"for x in 1 2; do :; done\n" +
+		"case $y in a|b) f ;; *) g ;;& esac\n" +
+		"name() { h; }\n" +
+		"[[ -n $z && ( a < b ) ]]\n" +
+		"! (( z++ ))\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if len(file.List.Items) != 6 {
+		t.Fatal("Expected 6 and-or lists, got", len(file.List.Items))
+	}
+	cmd := func(i int) Cmd { return file.List.Items[i].Pipelines[0].Cmds[0] }
+
+	ifClause, ok := cmd(0).(*IfClause)
+	if !ok || len(ifClause.Elifs) != 1 || ifClause.ElseBody == nil || len(ifClause.Redirects) != 1 {
+		t.Fatal("Expected if with elif, else and redirection")
+	}
+	if ifClause.Fi.Pos() != 38 || ifClause.End() != 45 {
+		t.Error("Expected fi at 38 and end at 45, got", ifClause.Fi.Pos(), ifClause.End())
+	}
+
+	forClause, ok := cmd(1).(*ForClause)
+	if !ok || forClause.Name.Value != "x" || len(forClause.Words) != 2 || forClause.Done.Value != "done" {
+		t.Error("Expected for loop over 2 words")
+	}
+
+	caseClause, ok := cmd(2).(*CaseClause)
+	if !ok || len(caseClause.Items) != 2 || len(caseClause.Items[0].Patterns) != 2 {
+		t.Fatal("Expected case with 2 items")
+	}
+	if caseClause.Items[1].Op.Value != ";;&" || caseClause.Esac.Value != "esac" {
+		t.Error("Expected ;;& and esac, got", caseClause.Items[1].Op.Value, caseClause.Esac.Value)
+	}
+
+	decl, ok := cmd(3).(*FuncDecl)
+	if !ok || decl.Name.Value != "name" {
+		t.Fatal("Expected function name")
+	}
+	if _, ok := decl.Body.(*Block); !ok {
+		t.Error("Expected block as function body")
+	}
+
+	test, ok := cmd(4).(*TestClause)
+	if !ok || len(test.Words) != 8 || test.Words[2].Lit() != "&&" {
+		t.Error("Expected [[ with 8 words")
+	}
+
+	pipeline := file.List.Items[5].Pipelines[0]
+	if _, ok := pipeline.Cmds[0].(*ArithmCmd); !ok || pipeline.Bang.Value != "!" {
+		t.Error("Expected negated arithmetic command")
+	}
+
+	for _, s := range []string{"if a; then b\n", "while a; b; done\n", "case x in a) b\n", "(( 1 + 2\n", "fi\n"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestParseSubstitutionKinds(t *testing.T) {
+	s := "diff <(sort a) >(tee b) < <(ls) $(( (1 + 2) * $(nproc) ))\n"
+
+	file, err := Parse(s)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	cmd := file.List.Items[0].Pipelines[0].Cmds[0].(*SimpleCommand)
+	if len(cmd.Words) != 4 || len(cmd.Redirects) != 1 {
+		t.Fatal("Expected 4 words and 1 redirection, got", len(cmd.Words), len(cmd.Redirects))
+	}
+	in, ok := cmd.Words[1].Parts[0].(*ProcSubst)
+	if !ok || in.Left.Value != "<(" || in.Pos() != 5 || in.End() != 14 {
+		t.Error("Expected process substitution at 5-14")
+	}
+	if out, ok := cmd.Words[2].Parts[0].(*ProcSubst); !ok || out.Left.Value != ">(" {
+		t.Error("Expected output process substitution")
+	}
+	if _, ok := cmd.Redirects[0].Word.Parts[0].(*ProcSubst); !ok {
+		t.Error("Expected redirection from process substitution")
+	}
+
+	exp, ok := cmd.Words[3].Parts[0].(*ArithmExp)
+	if !ok || exp.Pos() != 32 || exp.End() != 57 {
+		t.Fatal("Expected arithmetic expansion at 32-57")
+	}
+	if len(exp.Parts) != 3 {
+		t.Error("Expected expression with a substitution, got", len(exp.Parts), "parts")
+	}
+}
@@ -0,0 +1,61 @@
+package cmds
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Offset returns the byte offset in the script of the position at a line and
+// column, both counting from 1. The column counts runes, not bytes. A column
+// past the end of the line gives the offset of the end of the line, and a
+// line past the end of the script gives the length of the script.
+func Offset(script string, line, col int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		i := strings.IndexByte(script[offset:], '\n')
+		if i < 0 {
+			return len(script)
+		}
+		offset += i + 1
+	}
+
+	for ; col > 1 && offset < len(script) && script[offset] != '\n'; col-- {
+		_, size := utf8.DecodeRuneInString(script[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+// Position returns the line and column, both counting from 1, of a byte
+// offset in the script. The column counts runes, not bytes. An offset inside
+// a multibyte rune gives the position of that rune.
+func Position(script string, offset int) (int, int) {
+	if offset > len(script) {
+		offset = len(script)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	for offset > 0 && offset < len(script) && !utf8.RuneStart(script[offset]) {
+		offset--
+	}
+
+	start := strings.LastIndexByte(script[:offset], '\n') + 1
+	line := strings.Count(script[:start], "\n") + 1
+
+	return line, utf8.RuneCountInString(script[start:offset]) + 1
+}
+
+// FindAt returns the command being worked on at a line and column, as Find
+// does at the offset of that position. Lines and columns count from 1 and
+// columns count runes.
+func FindAt(script string, line, col int) (*Command, error) {
+	return Find(script, Offset(script, line, col))
+}
+
+// FindAt returns the command being worked on at a line and column, as FindAt
+// does for the text of the script.
+func (s *Script) FindAt(line, col int) (*Command, error) {
+	return s.Find(Offset(s.src, line, col))
+}
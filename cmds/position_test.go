@@ -0,0 +1,79 @@
+package cmds
+
+import "testing"
+
+func TestOffset(t *testing.T) {
+	script := "# Größe prüfen\necho 'héllo 👋' | wc -c\nls\n"
+
+	tests := []struct {
+		line, col int
+		offset    int
+	}{
+		{1, 1, 0},
+		{1, 4, 3},
+		{1, 5, 4},
+		{1, 6, 6},
+		{1, 15, 17},
+		{1, 40, 17},
+		{2, 1, 18},
+		{2, 13, 31},
+		{2, 16, 37},
+		{3, 3, 47},
+		{9, 1, 48},
+	}
+
+	for _, test := range tests {
+		if offset := Offset(script, test.line, test.col); offset != test.offset {
+			t.Errorf("%d:%d: expected offset %d, got %d", test.line, test.col, test.offset, offset)
+		}
+		if test.line > 3 || test.col > 20 {
+			continue
+		}
+		if line, col := Position(script, test.offset); line != test.line || col != test.col {
+			t.Errorf("%d: expected %d:%d, got %d:%d", test.offset, test.line, test.col, line, col)
+		}
+	}
+
+	if line, col := Position(script, 32); line != 2 || col != 13 {
+		t.Error("Expected an offset inside 👋 to be at 2:13, got", line, col)
+	}
+	if line, col := Position(script, -5); line != 1 || col != 1 {
+		t.Error("Expected a negative offset to be at 1:1, got", line, col)
+	}
+	if line, col := Position(script, 100); line != 4 || col != 1 {
+		t.Error("Expected an offset past the end to be at 4:1, got", line, col)
+	}
+}
+
+func TestFindAt(t *testing.T) {
+	script := "# Größe prüfen\necho 'héllo 👋' | wc -c\nls\n"
+
+	tests := []struct {
+		line, col int
+		name      string
+	}{
+		{2, 1, "echo"},
+		{2, 13, "echo"},
+		{2, 14, "echo"},
+		{2, 18, "wc"},
+		{2, 21, "wc"},
+		{3, 2, "ls"},
+	}
+
+	for _, test := range tests {
+		cmd, err := FindAt(script, test.line, test.col)
+		if err != nil || cmd.Name != test.name {
+			t.Errorf("%d:%d: expected %s, got %v, %v", test.line, test.col, test.name, cmd, err)
+		}
+	}
+
+	if _, err := FindAt(script, 1, 5); err != ErrNoCommand {
+		t.Error("Expected no command in the comment, got", err)
+	}
+
+	s := &Script{}
+	s.Update(script)
+	if cmd, err := s.FindAt(2, 19); err != nil || cmd.Name != "wc" || cmd.Start != 39 {
+		t.Error("Expected wc at 39, got", cmd, err)
+	}
+}
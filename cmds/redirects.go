@@ -0,0 +1,138 @@
+package cmds
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Redirection is a redirection of one of a command's file descriptors.
+type Redirection struct {
+	Fd         int    // file descriptor redirected, 1 for &> and &>>, which redirect 2 as well
+	Op         string // operator, such as > or <&
+	Target     string // file, file descriptor, - to close, heredoc delimiter or here string
+	Start, End int    // byte range of the redirection in the script
+}
+
+// newRedirection creates the redirection for a redirect in the script.
+func newRedirection(script string, redirect *Redirect) Redirection {
+	r := Redirection{Op: redirect.Op.Value, Start: int(redirect.Pos()), End: int(redirect.End())}
+
+	r.Fd = 1
+	if redirect.N != nil {
+		r.Fd, _ = strconv.Atoi(redirect.N.Value)
+	} else if r.Op[0] == '<' {
+		r.Fd = 0
+	}
+
+	if redirect.Word != nil {
+		r.Target = redirect.Word.Lit()
+		if r.Target == "" {
+			r.Target = script[redirect.Word.Pos():redirect.Word.End()]
+		}
+	}
+
+	return r
+}
+
+// String returns the redirection as it would be written, with its file
+// descriptor.
+func (r Redirection) String() string {
+	if r.Op[0] == '&' {
+		return r.Op + r.Target
+	}
+
+	return strconv.Itoa(r.Fd) + r.Op + r.Target
+}
+
+// Reads reports whether the target is a file that is read from.
+func (r Redirection) Reads() bool {
+	return r.Op == "<" || r.Op == "<>" || r.Op == "<&" && r.toFile()
+}
+
+// Writes reports whether the target is a file that is written to.
+func (r Redirection) Writes() bool {
+	switch r.Op {
+	case ">", ">>", ">|", "&>", "&>>", "<>":
+		return true
+	case ">&":
+		return r.toFile()
+	}
+
+	return false
+}
+
+// Explain returns a description of what the redirection does.
+func (r Redirection) Explain() string {
+	fd := fdName(r.Fd)
+	if r.Op[0] == '&' || r.Op == ">&" && r.Fd == 1 && r.toFile() {
+		fd = "standard output and standard error"
+	}
+
+	switch r.Op {
+	case "<":
+		return fd + " is read from " + r.Target
+	case ">":
+		return fd + " is written to " + r.Target + ", replacing its contents"
+	case ">|":
+		return fd + " is written to " + r.Target + ", replacing its contents even if noclobber is set"
+	case ">>":
+		return fd + " is appended to " + r.Target
+	case "&>>":
+		return fd + " are appended to " + r.Target
+	case "&>":
+		return fd + " are written to " + r.Target + ", replacing its contents"
+	case "<>":
+		return r.Target + " is opened for reading and writing as " + fd
+	case "<<", "<<-":
+		return fd + " is read from the heredoc ending with " + r.Target
+	case "<<<":
+		return fd + " is read from the string " + r.Target
+	}
+
+	// <& and >& duplicate or close a file descriptor
+	switch {
+	case r.Target == "":
+		return fd + " has no target"
+	case r.Target == "-":
+		return fd + " is closed"
+	case r.toFile() && r.Op == ">&" && r.Fd == 1:
+		return fd + " are written to " + r.Target + ", replacing its contents"
+	case r.toFile():
+		return fd + " is a copy of " + r.Target
+	case strings.HasSuffix(r.Target, "-"):
+		return fd + " is moved from " + fdName(fdTarget(strings.TrimSuffix(r.Target, "-")))
+	}
+
+	return fd + " is a copy of " + fdName(fdTarget(r.Target))
+}
+
+// toFile reports whether the target of a <& or >& redirection is a file
+// rather than a file descriptor to copy or move, or - to close.
+func (r Redirection) toFile() bool {
+	target := strings.TrimSuffix(r.Target, "-")
+	return target != "" && fdTarget(target) < 0
+}
+
+// fdTarget returns the file descriptor in a target, or -1 if it isn't one.
+func fdTarget(target string) int {
+	fd, err := strconv.Atoi(target)
+	if err != nil || target[0] < '0' || target[0] > '9' {
+		return -1
+	}
+
+	return fd
+}
+
+// fdName returns the name of a file descriptor.
+func fdName(fd int) string {
+	switch fd {
+	case 0:
+		return "standard input"
+	case 1:
+		return "standard output"
+	case 2:
+		return "standard error"
+	}
+
+	return "file descriptor " + strconv.Itoa(fd)
+}
@@ -0,0 +1,317 @@
+package cmds
+
+import (
+	"sort"
+	"strings"
+)
+
+// Script is a script that is being edited. Each update only reparses the
+// lines around the edit, keeping the parse of the lines before and after it,
+// so finding the command being worked on stays fast in large scripts. The zero
+// value is an empty script.
+type Script struct {
+	src    string
+	chunks []*chunk
+
+	// The chunks from moved on are yet to be moved by shift bytes and
+	// shiftLines lines, so an edit only moves the chunks between it and the
+	// edit before
+	moved      int
+	shift      int
+	shiftLines int
+}
+
+// chunk is a run of whole lines that starts at the top level of the script,
+// so it parses the same on its own as it does in the whole script.
+type chunk struct {
+	start, end int   // byte range in the script
+	line       int   // line the chunk starts on, counting from 1
+	lines      int   // number of newlines in the chunk
+	starts     []int // offsets of the lines from the start of the chunk, made when first needed
+	parsed     int   // start of the chunk when its items were parsed
+	items      []*AndOr
+	err        error        // first syntax error in the chunk
+	defs       *Definitions // definitions in the chunk, made when first needed
+}
+
+// Update replaces the text of the script, reparsing the part that changed.
+func (s *Script) Update(script string) {
+	if script == s.src && s.chunks != nil {
+		return
+	}
+	old := s.src
+	delta := len(script) - len(old)
+
+	// Find the text in common before and after the edit
+	n := commonPrefix(old, script)
+	m := commonSuffix(old[n:], script[n:])
+
+	// Chunks before the edit are kept, except the last one, which ends the
+	// script and could be continued by added text
+	i := 0
+	if len(s.chunks) > 0 {
+		i = sort.Search(len(s.chunks)-1, func(k int) bool {
+			_, end := s.span(k)
+			return end > n
+		})
+	}
+	// Chunks after the edit are kept too, moved by the change in length
+	j := sort.Search(len(s.chunks), func(k int) bool {
+		start, _ := s.span(k)
+		return start >= len(old)-m
+	})
+	if j < i {
+		j = i
+	}
+	s.settle(j)
+
+	start, line := 0, 1
+	if i > 0 {
+		prev := s.chunks[i-1]
+		start, line = prev.end, prev.line+prev.lines
+	}
+	var chunks []*chunk
+	for tries := 0; ; tries++ {
+		end := len(script)
+		if j < len(s.chunks) {
+			s.settle(j + 1)
+			end = s.chunks[j].start + delta
+		}
+		var closed bool
+		chunks, closed = parseChunks(script, start, end, line)
+		if closed || j == len(s.chunks) {
+			break
+		}
+		// The edit left something open, so take in the next chunk, or the rest
+		// of the script if that isn't enough either
+		j++
+		if tries > 0 {
+			j = len(s.chunks)
+		}
+	}
+
+	lineDelta := 0
+	for _, c := range chunks {
+		lineDelta += c.lines
+	}
+	for _, c := range s.chunks[i:j] {
+		lineDelta -= c.lines
+	}
+	// The chunks up to those yet to be moved by an edit before are moved now,
+	// and the rest take this edit on along with the shift they have
+	s.settle(j)
+	if s.shift == 0 && s.shiftLines == 0 {
+		s.moved = j
+	}
+	for _, c := range s.chunks[j:s.moved] {
+		c.start += delta
+		c.end += delta
+		c.line += lineDelta
+	}
+	s.shift += delta
+	s.shiftLines += lineDelta
+
+	if len(chunks) == j-i {
+		// Most edits stay within a line, so the chunks can be replaced in place
+		copy(s.chunks[i:j], chunks)
+	} else {
+		s.chunks = append(append(s.chunks[:i:i], chunks...), s.chunks[j:]...)
+		s.moved += len(chunks) - (j - i)
+	}
+	if s.moved >= len(s.chunks) {
+		s.moved, s.shift, s.shiftLines = len(s.chunks), 0, 0
+	}
+	s.src = script
+}
+
+// span returns the byte range of chunk k, including any shift it is yet to
+// be moved by.
+func (s *Script) span(k int) (int, int) {
+	c := s.chunks[k]
+	if k < s.moved {
+		return c.start, c.end
+	}
+
+	return c.start + s.shift, c.end + s.shift
+}
+
+// settle moves the chunks before chunk k by the shift they are yet to be
+// moved by.
+func (s *Script) settle(k int) {
+	if k > len(s.chunks) {
+		k = len(s.chunks)
+	}
+	for ; s.moved < k; s.moved++ {
+		c := s.chunks[s.moved]
+		c.start += s.shift
+		c.end += s.shift
+		c.line += s.shiftLines
+	}
+	if s.moved == len(s.chunks) {
+		s.shift, s.shiftLines = 0, 0
+	}
+}
+
+// commonPrefix returns the length of the text that a and b start with. Runs
+// of growing length are compared at a time, so long texts take few compares.
+func commonPrefix(a, b string) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+
+	lo, size := 0, 64
+	for lo < max {
+		hi := lo + size
+		if hi > max {
+			hi = max
+		}
+		if a[lo:hi] != b[lo:hi] {
+			// Narrow down the run that differs
+			for hi-lo > 16 {
+				if mid := (lo + hi) / 2; a[lo:mid] == b[lo:mid] {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			for lo < hi && a[lo] == b[lo] {
+				lo++
+			}
+			return lo
+		}
+		lo, size = hi, size*2
+	}
+
+	return lo
+}
+
+// commonSuffix returns the length of the text that a and b end with, as
+// commonPrefix does for the start.
+func commonSuffix(a, b string) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+	suffix := func(s string, lo, hi int) string { return s[len(s)-hi : len(s)-lo] }
+
+	lo, size := 0, 64
+	for lo < max {
+		hi := lo + size
+		if hi > max {
+			hi = max
+		}
+		if suffix(a, lo, hi) != suffix(b, lo, hi) {
+			for hi-lo > 16 {
+				if mid := (lo + hi) / 2; suffix(a, lo, mid) == suffix(b, lo, mid) {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			for lo < hi && a[len(a)-1-lo] == b[len(b)-1-lo] {
+				lo++
+			}
+			return lo
+		}
+		lo, size = hi, size*2
+	}
+
+	return lo
+}
+
+// Find returns the command being worked on at offset, as Find does for the
+// text of the script.
+func (s *Script) Find(offset int) (*Command, error) {
+	if len(s.src) <= 1 {
+		return nil, ErrEmptyScript
+	}
+
+	i := sort.Search(len(s.chunks), func(k int) bool {
+		_, end := s.span(k)
+		return end > offset
+	})
+	if i == len(s.chunks) {
+		i--
+	}
+	s.settle(i + 1)
+	c := s.chunks[i]
+	items := s.items(c)
+
+	f := s.finder(c, c.line)
+	f.offset = Pos(offset)
+	return f.find(&List{Items: items}, c.err)
+}
+
+// finder returns a finder for the commands in a chunk, which counts lines
+// from the given line at the start of the chunk.
+func (s *Script) finder(c *chunk, line int) *Finder {
+	if c.starts == nil {
+		c.starts = lineStarts(s.src[c.start:c.end])
+	}
+
+	return &Finder{script: s.src, offset: -1, lines: c.starts, base: c.start, first: line}
+}
+
+// items returns the items of a chunk, parsing it again if it has moved, as
+// the positions in its items are then out of date.
+func (s *Script) items(c *chunk) []*AndOr {
+	if c.parsed != c.start {
+		chunks, _ := parseChunks(s.src, c.start, c.end, c.line)
+		c.items, c.err = nil, nil
+		for _, moved := range chunks {
+			c.items = append(c.items, moved.items...)
+			if c.err == nil {
+				c.err = moved.err
+			}
+		}
+		c.parsed = c.start
+	}
+
+	return c.items
+}
+
+// String returns the text of the script.
+func (s *Script) String() string {
+	return s.src
+}
+
+// parseChunks parses bytes start through end-1 of the script, which start at
+// the top level on the given line, into chunks. It reports whether the end is
+// at the top level as well, so that the rest of the script can be parsed on
+// its own.
+func parseChunks(script string, start, end, line int) ([]*chunk, bool) {
+	p := &parser{src: script[:end], root: script, eof: Pos(end), i: start}
+	items := p.list(0).Items
+
+	closed := start == end || p.err == nil && len(p.lines) > 0 && p.lines[len(p.lines)-1] == end
+
+	var chunks []*chunk
+	bounds := append([]int{start}, p.lines...)
+	for k, from := range bounds {
+		to := end
+		if k+1 < len(bounds) {
+			to = bounds[k+1]
+		}
+		if from >= to {
+			continue
+		}
+
+		c := &chunk{start: from, end: to, line: line, lines: strings.Count(script[from:to], "\n"), parsed: from}
+		line += c.lines
+		for len(items) > 0 && int(items[0].Pos()) < to {
+			c.items = append(c.items, items[0])
+			items = items[1:]
+		}
+		for _, err := range p.errs {
+			// Errors at the end of the source belong to the last chunk
+			if from <= int(err.Pos) && (int(err.Pos) < to || to == end) {
+				c.err = err
+				break
+			}
+		}
+		chunks = append(chunks, c)
+	}
+
+	return chunks, closed
+}
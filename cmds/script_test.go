@@ -0,0 +1,191 @@
+package cmds
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScriptUpdate(t *testing.T) {
+	edits := []string{
+		"ls -l\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m msg\n",
+		"ls -l\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m msg\necho done\n",
+		"ls -l | grep x\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m msg\necho done\n",
+		"ls -l | grep x\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m \"msg\necho done\n",
+		"ls -l | grep x\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m \"msg\"\necho done\n",
+		"ls -l | grep x\ncat <<END\n$(pwd)\nEOF\ngit commit -m \"msg\"\necho done\n",
+		"ls -l | grep x\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m \"msg\"\necho done\n",
+		"ls -l | grep x\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m \"msg\" \\\necho done\n",
+		"x=1 ls -l | grep x\ncat <<EOF\n$(pwd)\nEOF\ngit commit -m \"msg\" \\\necho done\n",
+		"x=1 ls -l | grep x\n# comment\necho done\n",
+		"x=1 ls -l | grep x\n# comment\necho $(\ndone\n",
+		"x=1 ls -l | grep x\n# comment\necho $(\ndone)\n",
+		"\n",
+		"sudo ls",
+		"sudo ls\npwd",
+	}
+
+	script := &Script{}
+	for _, edit := range edits {
+		script.Update(edit)
+		if script.String() != edit {
+			t.Fatalf("Expected %q, got %q", edit, script.String())
+		}
+
+		for offset := 0; offset <= len(edit); offset++ {
+			expected, expectedErr := Find(edit, offset)
+			cmd, err := script.Find(offset)
+			if !reflect.DeepEqual(cmd, expected) || fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+				t.Errorf("%q at %d: expected %v, %v, got %v, %v", edit, offset, expected, expectedErr, cmd, err)
+			}
+		}
+	}
+}
+
+func TestScriptReuse(t *testing.T) {
+	script := &Script{}
+	script.Update("ls\necho 'a\nb'\npwd\n")
+	if len(script.chunks) != 3 {
+		t.Fatal("Expected 3 chunks, got", len(script.chunks))
+	}
+	first, last := script.chunks[0], script.chunks[2]
+
+	script.Update("ls\necho 'a\nbc'\npwd\n")
+	if script.chunks[0] != first || script.chunks[2] != last {
+		t.Error("Expected the lines around the edit to be kept")
+	}
+	if start, end := script.span(2); start != 15 || end != 19 {
+		t.Error("Expected last chunk to move to 15-19, got", start, end)
+	}
+}
+
+func TestScriptEdits(t *testing.T) {
+	// Edits jump around the script, so chunks are moved by several edits
+	// before they are looked at
+	text := benchmarkScript(40)
+	rng := rand.New(rand.NewSource(1))
+	inserts := []string{" ", "x", "\n", "ls -l\n", "'", "\"", "f() {\n", "}\n", "<<EOF\n", "EOF\n", "$("}
+
+	script := &Script{}
+	script.Update(text)
+	for i := 0; i < 300; i++ {
+		at := rng.Intn(len(text) + 1)
+		if rng.Intn(3) == 0 && at < len(text) {
+			text = text[:at] + text[at+1+rng.Intn(len(text)-at)/8:]
+		} else {
+			text = text[:at] + inserts[rng.Intn(len(inserts))] + text[at:]
+		}
+		script.Update(text)
+
+		offset := rng.Intn(len(text) + 1)
+		expected, expectedErr := Find(text, offset)
+		cmd, err := script.Find(offset)
+		if !reflect.DeepEqual(cmd, expected) || fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+			t.Fatalf("Edit %d at %d: expected %v, %v, got %v, %v", i, offset, expected, expectedErr, cmd, err)
+		}
+		if i%10 == 0 {
+			expectedDefs, _ := FindDefinitions(text)
+			if defs := script.Definitions(); !reflect.DeepEqual(defs, expectedDefs) {
+				t.Fatalf("Edit %d: expected definitions %v, got %v", i, expectedDefs, defs)
+			}
+		}
+	}
+}
+
+// benchmarkScript returns a script with the given number of lines.
+func benchmarkScript(lines int) string {
+	var script strings.Builder
+	for i := 0; i < lines; i++ {
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&script, "sudo apt-get install -y package%d | tee -a /var/log/install.log\n", i)
+		case 1:
+			fmt.Fprintf(&script, "if_ok=\"$(systemctl is-active service%d)\" && echo \"$if_ok\"\n", i)
+		case 2:
+			script.WriteString("cat <<EOF > /etc/config\nvalue=$(hostname)\nEOF\n")
+		default:
+			fmt.Fprintf(&script, "git -C /srv/repo%d commit -m 'provision' \\\n    --author admin\n", i)
+		}
+	}
+
+	return script.String()
+}
+
+// benchmarkEdit types a character in the middle of a script and finds the
+// command at the cursor, using find.
+func benchmarkEdit(b *testing.B, lines int, find func(script string, offset int) (*Command, error)) {
+	script := benchmarkScript(lines)
+	offset := strings.Index(script[len(script)/2:], "sudo") + len(script)/2 + 4
+	edits := []string{script[:offset] + " " + script[offset:], script}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := find(edits[i%2], offset); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFind(b *testing.B) {
+	for _, lines := range []int{100, 1000, 3000} {
+		b.Run(fmt.Sprintf("lines=%d", lines), func(b *testing.B) {
+			benchmarkEdit(b, lines, Find)
+		})
+	}
+}
+
+// benchmarkUpdate benchmarks typing in a script with the given number of
+// lines that is updated incrementally.
+func benchmarkUpdate(b *testing.B, lines int) {
+	script := &Script{}
+	benchmarkEdit(b, lines, func(text string, offset int) (*Command, error) {
+		script.Update(text)
+		return script.Find(offset)
+	})
+}
+
+func BenchmarkScriptUpdate(b *testing.B) {
+	for _, lines := range []int{100, 1000, 3000} {
+		b.Run(fmt.Sprintf("lines=%d", lines), func(b *testing.B) {
+			benchmarkUpdate(b, lines)
+		})
+	}
+}
+
+func TestScriptUpdateScales(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping timing in short mode")
+	}
+
+	// The fastest of a few runs is the least disturbed by other work
+	cost := func(lines int) time.Duration {
+		text := benchmarkScript(lines)
+		offset := strings.Index(text[len(text)/2:], "sudo") + len(text)/2 + 4
+		edits := []string{text[:offset] + " " + text[offset:], text}
+
+		script := &Script{}
+		script.Update(text)
+		best := time.Duration(0)
+		for run := 0; run < 5; run++ {
+			start := time.Now()
+			for i := 0; i < 200; i++ {
+				script.Update(edits[i%2])
+				script.Find(offset)
+			}
+			if elapsed := time.Since(start); best == 0 || elapsed < best {
+				best = elapsed
+			}
+		}
+		return best
+	}
+
+	// Only comparing the old and new text grows with the script, so 30 times
+	// the lines should cost well under 30 times as much
+	small, large := cost(100), cost(3000)
+	if large > 4*small {
+		t.Errorf("Expected a keystroke to cost about the same at 3000 lines as at 100, got %v and %v", large/200, small/200)
+	}
+}
@@ -0,0 +1,93 @@
+package cmds
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// subcommander describes a command whose first arguments name a subcommand,
+// such as git or docker.
+type subcommander struct {
+	optSpec                   // global options, given before the subcommand
+	groups  map[string]bool   // subcommands that have subcommands of their own
+	aliases map[string]string // abbreviations of subcommands
+}
+
+var regexSubcommand = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+var dockerGroups = map[string]bool{
+	"builder": true, "config": true, "container": true, "context": true, "image": true, "manifest": true,
+	"network": true, "node": true, "plugin": true, "secret": true, "service": true, "stack": true,
+	"swarm": true, "system": true, "trust": true, "volume": true,
+}
+
+var subcommanders = map[string]subcommander{
+	"cargo": {},
+	"docker": {
+		optSpec: optSpec{"cHl", []string{"--config", "--context", "--host", "--log-level"}},
+		groups:  dockerGroups,
+	},
+	"gh": {},
+	"git": {
+		optSpec: optSpec{"Cc", []string{"--config-env", "--git-dir", "--namespace", "--work-tree"}},
+	},
+	"ip": {
+		optSpec: optSpec{"bfn", []string{"-batch", "-family", "-netns"}},
+		aliases: map[string]string{
+			"a": "address", "addr": "address", "l": "link", "m": "maddress", "maddr": "maddress",
+			"mon": "monitor", "n": "neighbour", "neigh": "neighbour", "neighbor": "neighbour",
+			"nh": "nexthop", "r": "route", "ro": "route", "ru": "rule", "tun": "tunnel", "x": "xfrm",
+		},
+	},
+	"kubectl": {
+		optSpec: optSpec{"ns", []string{"--cluster", "--context", "--kubeconfig", "--namespace", "--server", "--user"}},
+		groups:  map[string]bool{"config": true, "create": true, "rollout": true, "set": true},
+	},
+	"npm":     {},
+	"openssl": {},
+	"podman": {
+		optSpec: optSpec{argLongOpts: []string{"--connection", "--log-level", "--root", "--url"}},
+		groups:  dockerGroups,
+	},
+}
+
+// subcommands returns the subcommands at the start of a command's arguments,
+// along with the options and positional arguments of the command and the
+// number of words used by the subcommands and the options before them. The
+// options before the subcommands are global.
+func subcommands(name string, words []*Word) ([]string, []Option, []string, int) {
+	s, ok := subcommanders[path.Base(name)]
+	if !ok {
+		opts, args := knownOpts[path.Base(name)].parseOptions(words)
+		return nil, opts, args, 0
+	}
+
+	var subs []string
+	var opts []Option
+	i := 0
+	for i < len(words) {
+		word := words[i].Lit()
+		if len(subs) == 0 && isOption(word) {
+			opt, n := s.option(words[i:])
+			opt.Global = true
+			opts = append(opts, opt)
+			i += n
+			continue
+		}
+		if !regexSubcommand.MatchString(word) || len(subs) > 0 && !s.groups[subs[0]] || len(subs) > 1 {
+			break
+		}
+
+		if alias, ok := s.aliases[word]; ok {
+			word = alias
+		}
+		subs = append(subs, word)
+		i++
+	}
+
+	key := strings.Join(append([]string{path.Base(name)}, subs...), " ")
+	subOpts, args := knownOpts[key].parseOptions(words[i:])
+
+	return subs, append(opts, subOpts...), args, i
+}
@@ -0,0 +1,96 @@
+package cmds
+
+import (
+	"path"
+	"strings"
+)
+
+// wrapper describes a command that runs another command given in its
+// arguments, such as sudo or xargs.
+type wrapper struct {
+	optSpec
+	assigns bool // whether NAME=value arguments can come before the command
+	args    int  // number of arguments before the command
+}
+
+var wrappers = map[string]wrapper{
+	"env": {
+		optSpec: optSpec{"uCS", []string{"--unset", "--chdir", "--split-string"}},
+		assigns: true,
+	},
+	"exec": {
+		optSpec: optSpec{argOpts: "a"},
+	},
+	"nice": {
+		optSpec: optSpec{"n", []string{"--adjustment"}},
+	},
+	"nohup": {},
+	"sudo": {
+		optSpec: optSpec{"CDghprRtTUu", []string{"--chdir", "--chroot", "--close-from", "--command-timeout",
+			"--group", "--host", "--other-user", "--prompt", "--role", "--type", "--user"}},
+		assigns: true,
+	},
+	"time": {
+		optSpec: optSpec{"fo", []string{"--format", "--output"}},
+	},
+	"timeout": {
+		optSpec: optSpec{"ks", []string{"--kill-after", "--signal"}},
+		args:    1,
+	},
+	"xargs": {
+		optSpec: optSpec{"adEILnPs", []string{"--arg-file", "--delimiter", "--max-args", "--max-chars",
+			"--max-lines", "--max-procs", "--process-slot-var"}},
+	},
+}
+
+// unwrap returns the number of words used by the wrapper named by words[0],
+// along with its options and arguments and the assignments for the command it
+// runs. It returns 0 if the name isn't a wrapper.
+func unwrap(words []*Word) (int, []Option, []string, []string) {
+	w, ok := wrappers[path.Base(words[0].Lit())]
+	if !ok {
+		return 0, nil, nil, nil
+	}
+
+	i := 1
+	var opts []Option
+	var args, assigns []string
+	for i < len(words) {
+		word := words[i].Lit()
+		if word == "--" {
+			i++
+			break
+		}
+		if isOption(word) {
+			opt, n := w.option(words[i:])
+			opts = append(opts, opt)
+			i += n
+			continue
+		}
+		if w.assigns && isAssign(word) {
+			assigns = append(assigns, word)
+			i++
+			continue
+		}
+		break
+	}
+	for ; i < len(words) && len(args) < w.args; i++ {
+		args = append(args, words[i].Lit())
+	}
+
+	return i, opts, args, assigns
+}
+
+func isAssign(word string) bool {
+	eq := strings.IndexByte(word, '=')
+	if eq <= 0 || word[0] >= '0' && word[0] <= '9' {
+		return false
+	}
+	for i := 0; i < eq; i++ {
+		if !isNameByte(word[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -6,19 +6,26 @@ package config
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"github.com/bryce/bashly/boxes"
 )
 
 // Config is the configuration format for the application.
 type Config struct {
-	LogsDirectory string         `json:"logsDirectory"`
-	Boxes         []boxes.Config `json:"boxes"`
+	LogsDirectory  string         `json:"logsDirectory"`
+	CacheDirectory string         `json:"cacheDirectory"` // manual pages are only cached in memory if empty
+	CacheSize      int64          `json:"cacheSize"`      // bytes of manual pages cached in memory
+	CacheDirSize   int64          `json:"cacheDirSize"`   // bytes of manual pages cached in the cache directory
+	RunHelp        bool           `json:"runHelp"`        // commands without other docs are run with --help
+	Boxes          []boxes.Config `json:"boxes"`
 }
 
 // Default loads the default application configuration.
 func Default() *Config {
 	cfg := &Config{}
+	cfg.CacheDirectory = CacheDirectory()
 	cfg.Boxes = []boxes.Config{}
 
 	box := boxes.Config{}
@@ -54,6 +61,21 @@ func Default() *Config {
 	return cfg
 }
 
+// CacheDirectory returns the default directory for caching manual pages,
+// XDG_CACHE_HOME/bashly, or "" if there is no cache directory.
+func CacheDirectory() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+
+	return filepath.Join(dir, "bashly")
+}
+
 // Load loads the application configuration from a file.
 func Load(cfgFile string) (*Config, error) {
 	bytes, err := ioutil.ReadFile(cfgFile)
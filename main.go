@@ -8,6 +8,7 @@ import (
 
 	"github.com/bryce/bashly/boxes"
 	"github.com/bryce/bashly/config"
+	"github.com/bryce/bashly/manual"
 	"github.com/jroimartin/gocui"
 )
 
@@ -30,6 +31,12 @@ func main() {
 	if cfg.LogsDirectory != "" {
 		file := setupLogging(cfg.LogsDirectory)
 		defer file.Close()
+		defer func() { log.Println("manual page cache:", manual.Stats()) }()
+	}
+
+	manual.SetCache(manual.NewCache(cfg.CacheSize, cfg.CacheDirectory, cfg.CacheDirSize))
+	if cfg.RunHelp {
+		manual.EnableUsage()
 	}
 
 	boxs, err := boxes.New(cfg.Boxes)
@@ -113,6 +120,10 @@ func setupScript(gui *gocui.Gui, boxs *boxes.Boxes, scriptFile string) {
 		log.Panicln(err)
 	}
 
+	if script, ok := boxs.Current().(*boxes.Script); ok {
+		script.SetFile(scriptFile)
+	}
+
 	scriptView, err := gui.View(boxs.Current().Name())
 	if err != nil {
 		log.Panicln(err)
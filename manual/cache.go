@@ -0,0 +1,219 @@
+package manual
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/youtube/vitess/go/cache"
+)
+
+// DefaultCacheSize is the number of bytes of pages kept in memory by default.
+const DefaultCacheSize = 4 << 20
+
+// DefaultCacheDirSize is the number of bytes of pages kept on disk by default.
+const DefaultCacheDirSize = 64 << 20
+
+// Cache keeps the pages that have been loaded in memory, up to a budget of
+// bytes, and optionally on disk so that they outlast the session. A page is
+// only used while the file it was made from is unchanged. The least recently
+// used pages on disk are removed when they go over their own budget.
+type Cache struct {
+	lru          *cache.LRUCache
+	dir          string // directory of the pages on disk, "" to keep them in memory only
+	dirSize      int64  // budget of bytes on disk
+	hits, misses int64
+}
+
+// CacheStats are statistics about the use of a cache.
+type CacheStats struct {
+	Hits, Misses int64
+	Pages        int64 // number of pages in memory
+	Size         int64 // bytes of pages in memory
+	Capacity     int64 // budget of bytes in memory
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %d pages in memory using %d of %d bytes",
+		s.Hits, s.Misses, s.Pages, s.Size, s.Capacity)
+}
+
+// cacheKey identifies a page in a cache.
+type cacheKey struct {
+	Provider string
+	Name     string // name of the page, such as git-commit
+	Section  string // section of a manual page, "" if not known
+	Width    int
+}
+
+func (k cacheKey) String() string {
+	return k.Provider + " " + k.Name + "(" + k.Section + ") " + strconv.Itoa(k.Width)
+}
+
+// cacheEntry is a page in a cache along with the file it was made from.
+type cacheEntry struct {
+	Page    Page
+	Source  string    // file the page was made from, "" if none
+	ModTime time.Time // modification time of the source when the page was made
+}
+
+// Size gets the size of the entry as used in the cache.
+func (e *cacheEntry) Size() int {
+	return len(e.Page)
+}
+
+var pageCache = NewCache(DefaultCacheSize, "", 0)
+
+// NewCache creates a cache that keeps up to size bytes of pages in memory,
+// or DefaultCacheSize bytes if size isn't positive. If dir isn't "", pages
+// are also kept on disk in dir, up to dirSize bytes or DefaultCacheDirSize
+// bytes if dirSize isn't positive.
+func NewCache(size int64, dir string, dirSize int64) *Cache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	if dirSize <= 0 {
+		dirSize = DefaultCacheDirSize
+	}
+
+	return &Cache{lru: cache.NewLRUCache(size), dir: dir, dirSize: dirSize}
+}
+
+// SetCache sets the cache used for the pages of the standard providers.
+func SetCache(c *Cache) {
+	pageCache = c
+}
+
+// Stats returns the statistics of the cache used by the standard providers.
+func Stats() CacheStats {
+	return pageCache.Stats()
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() CacheStats {
+	pages, size, capacity, _ := c.lru.Stats()
+	return CacheStats{Hits: atomic.LoadInt64(&c.hits), Misses: atomic.LoadInt64(&c.misses),
+		Pages: pages, Size: size, Capacity: capacity}
+}
+
+// get returns the page for a key if it was made from the current version of
+// the source file.
+func (c *Cache) get(key cacheKey, source string) (Page, bool) {
+	modTime := modTime(source)
+
+	if val, ok := c.lru.Get(key.String()); ok {
+		if entry := val.(*cacheEntry); entry.Source == source && entry.ModTime.Equal(modTime) {
+			atomic.AddInt64(&c.hits, 1)
+			return entry.Page, true
+		}
+	}
+
+	if c.dir != "" {
+		entry := &cacheEntry{}
+		path := c.path(key)
+		if bytes, err := ioutil.ReadFile(path); err == nil && json.Unmarshal(bytes, entry) == nil &&
+			entry.Source == source && entry.ModTime.Equal(modTime) {
+			// The modification time of a page on disk is when it was last used
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			c.lru.Set(key.String(), entry)
+			atomic.AddInt64(&c.hits, 1)
+			return entry.Page, true
+		}
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+	return nil, false
+}
+
+// set adds the page for a key, made from the source file, to the cache.
+func (c *Cache) set(key cacheKey, source string, page Page) {
+	entry := &cacheEntry{Page: page, Source: source, ModTime: modTime(source)}
+	c.lru.Set(key.String(), entry)
+
+	if c.dir == "" {
+		return
+	}
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// Pages are written whole, so that a reader never sees part of one
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(c.dir, "page")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(bytes)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// A page made from an older version of the source is replaced
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	c.prune()
+}
+
+// prune removes the least recently used pages on disk until they fit in the
+// budget of bytes on disk.
+func (c *Cache) prune() {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	size := int64(0)
+	for _, file := range files {
+		size += file.Size()
+	}
+	if size <= c.dirSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, file := range files {
+		if size <= c.dirSize {
+			break
+		}
+		if file.Mode().IsRegular() && os.Remove(filepath.Join(c.dir, file.Name())) == nil {
+			size -= file.Size()
+		}
+	}
+}
+
+// path returns the path of the file for a key on disk.
+func (c *Cache) path(key cacheKey) string {
+	sum := sha256.Sum256([]byte(key.String()))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// modTime returns the modification time of a file, or the zero time if it
+// can't be found.
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
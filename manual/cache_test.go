@@ -0,0 +1,117 @@
+package manual
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "ls.1")
+	if err := ioutil.WriteFile(source, []byte(".TH LS 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key := cacheKey{Provider: "man", Name: "ls", Section: "1", Width: 80}
+	if key.String() != "man ls(1) 80" {
+		t.Error("Expected the width in decimal in the key, got", key.String())
+	}
+
+	c := NewCache(0, filepath.Join(dir, "cache"), 0)
+	if _, ok := c.get(key, source); ok {
+		t.Error("Expected a miss in an empty cache")
+	}
+	c.set(key, source, Page("LS(1)"))
+
+	// A new session only has the pages on disk
+	c = NewCache(0, filepath.Join(dir, "cache"), 0)
+	if page, ok := c.get(key, source); !ok || string(page) != "LS(1)" {
+		t.Error("Expected the page from disk, got", string(page), ok)
+	}
+	if _, ok := c.get(cacheKey{Provider: "man", Name: "ls", Section: "1", Width: 100}, source); ok {
+		t.Error("Expected a miss for another width")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(source, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get(key, source); ok {
+		t.Error("Expected a miss after the source changed")
+	}
+
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Error("Expected 1 hit and 2 misses, got", stats)
+	}
+
+	// The stale page on disk is replaced rather than kept beside the new one
+	c.set(key, source, Page("LS(1) new"))
+	files, err := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	if err != nil || len(files) != 1 {
+		t.Error("Expected one page on disk, got", len(files), err)
+	}
+	c = NewCache(0, filepath.Join(dir, "cache"), 0)
+	if page, ok := c.get(key, source); !ok || string(page) != "LS(1) new" {
+		t.Error("Expected the new page from disk, got", string(page), ok)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	page := Page(strings.Repeat("x", 100))
+	keys := []cacheKey{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	c := NewCache(0, dir, 0)
+	c.set(keys[0], "", page)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatal("Expected one page on disk, got", len(files), err)
+	}
+
+	// Room for two pages on disk
+	c = NewCache(0, dir, 2*files[0].Size()+1)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(c.path(keys[0]), past, past)
+	c.set(keys[1], "", page)
+	os.Chtimes(c.path(keys[1]), past.Add(time.Minute), past.Add(time.Minute))
+
+	// Using a is enough to keep it over b
+	c = NewCache(0, dir, 2*files[0].Size()+1)
+	if _, ok := c.get(keys[0], ""); !ok {
+		t.Fatal("Expected a from disk")
+	}
+	c.set(keys[2], "", page)
+
+	files, err = ioutil.ReadDir(dir)
+	if err != nil || len(files) != 2 {
+		t.Error("Expected two pages on disk, got", len(files), err)
+	}
+	c = NewCache(0, dir, 0)
+	for i, expected := range []bool{true, false, true} {
+		if _, ok := c.get(keys[i], ""); ok != expected {
+			t.Errorf("%s: expected on disk %v, got %v", keys[i], expected, ok)
+		}
+	}
+}
+
+func TestCacheBudget(t *testing.T) {
+	c := NewCache(10, "", 0)
+	c.set(cacheKey{Name: "a"}, "", Page("aaaa"))
+	c.set(cacheKey{Name: "b"}, "", Page("bbbb"))
+	c.set(cacheKey{Name: "c"}, "", Page("cccc"))
+
+	if _, ok := c.get(cacheKey{Name: "a"}, ""); ok {
+		t.Error("Expected the oldest page to be evicted")
+	}
+	if page, ok := c.get(cacheKey{Name: "c"}, ""); !ok || string(page) != "cccc" {
+		t.Error("Expected the newest page to be kept, got", string(page), ok)
+	}
+
+	stats := c.Stats()
+	if stats.Pages != 2 || stats.Size != 8 || stats.Capacity != 10 {
+		t.Error("Expected 2 pages using 8 of 10 bytes, got", stats)
+	}
+}
@@ -0,0 +1,126 @@
+package manual
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// Man provides the manual pages found in MANPATH, which it renders itself so
+// that man doesn't need to be installed. For a command with subcommands, the
+// pages named after them, such as git-commit, are tried before the page for
+// the command itself. The section of the command is used if it has one.
+type Man struct{}
+
+// validSection matches the manual sections that can be asked for, such as 1
+// or 3p.
+var validSection = regexp.MustCompile(`^[0-9a-z]+$`)
+
+// Name returns the name of the provider.
+func (Man) Name() string {
+	return "man"
+}
+
+// Layout returns the layout of the options in manual pages.
+func (Man) Layout() Layout {
+	return ManLayout
+}
+
+// Get returns the manual page for a command.
+func (m Man) Get(ctx context.Context, command *cmds.Command, width int) (Page, error) {
+	name, source, err := m.which(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file of the page tells whether a cached page is still current
+	key := cacheKey{Provider: "man", Name: name, Section: sectionOf(source), Width: width}
+	if page, ok := pageCache.get(key, source); ok {
+		return page, nil
+	}
+
+	src, err := readPage(source)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	page := renderRoff(src, width)
+	pageCache.set(key, source, page)
+	return page, nil
+}
+
+// Which returns the name and section of the manual page that is shown for a
+// command, such as printf and 1.
+func (m Man) Which(ctx context.Context, command *cmds.Command) (string, string, error) {
+	name, source, err := m.which(ctx, command)
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, sectionOf(source), nil
+}
+
+// which returns the name and the file of the manual page for a command.
+func (Man) which(ctx context.Context, command *cmds.Command) (string, string, error) {
+	if command.Kind == cmds.Reserved || !validCommand(command) ||
+		command.Section != "" && !validSection.MatchString(command.Section) {
+		return "", "", ErrNoPage
+	}
+
+	for i := len(command.Subcommands); i >= 0; i-- {
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+		name := strings.Join(append([]string{command.Name}, command.Subcommands[:i]...), "-")
+		if pages := findPages(name, command.Section); len(pages) > 0 {
+			return name, pages[0], nil
+		}
+	}
+
+	return "", "", ErrNoPage
+}
+
+// Sections returns the sections that have a manual page with the given name,
+// in the order man looks through them.
+func Sections(ctx context.Context, name string) ([]string, error) {
+	if !validName.MatchString(name) {
+		return nil, ErrNoPage
+	}
+	pages := findPages(name, "")
+	if len(pages) == 0 {
+		return nil, ErrNoPage
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var sections []string
+	seen := map[string]bool{}
+	for _, source := range pages {
+		if section := sectionOf(source); section != "" && !seen[section] {
+			sections = append(sections, section)
+			seen[section] = true
+		}
+	}
+
+	return sections, nil
+}
+
+// sectionOf returns the section of a manual page from the name of its file,
+// such as 3 for /usr/share/man/man3/printf.3.gz.
+func sectionOf(source string) string {
+	base := trimCompression(filepath.Base(source))
+
+	i := strings.LastIndexByte(base, '.')
+	if i < 0 {
+		return ""
+	}
+
+	return base[i+1:]
+}
@@ -0,0 +1,174 @@
+package manual
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// fakeManPath points MANPATH at a directory with a few manual pages.
+func fakeManPath(t *testing.T) {
+	dir := t.TempDir()
+	pages := []string{"man1/printf.1.gz", "man3/printf.3", "man1/git-commit.1", "man1/git.1", "man1/openssl-req.1ssl.gz"}
+	for _, page := range pages {
+		path := filepath.Join(dir, page)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		src := []byte(".TH PAGE 1\n")
+		if strings.HasSuffix(page, ".gz") {
+			var compressed bytes.Buffer
+			writer := gzip.NewWriter(&compressed)
+			writer.Write(src)
+			writer.Close()
+			src = compressed.Bytes()
+		}
+		if err := ioutil.WriteFile(path, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("MANPATH", dir)
+}
+
+func TestSections(t *testing.T) {
+	fakeManPath(t)
+
+	tests := []struct {
+		name     string
+		sections string
+	}{
+		{"printf", "1|3"},
+		{"openssl-req", "1ssl"},
+		{"missing", ""},
+	}
+
+	for _, test := range tests {
+		sections, _ := Sections(context.Background(), test.name)
+		if strings.Join(sections, "|") != test.sections {
+			t.Errorf("%s: expected sections %s, got %v", test.name, test.sections, sections)
+		}
+	}
+}
+
+func TestWhich(t *testing.T) {
+	fakeManPath(t)
+
+	tests := []struct {
+		script  string
+		section string
+		page    string
+	}{
+		{"printf x\n", "", "printf(1)"},
+		{"printf x\n", "3", "printf(3)"},
+		{"git commit -m x\n", "", "git-commit(1)"},
+		{"git status\n", "", "git(1)"},
+		{"printf x\n", "8", ""},
+		{"printf x\n", "1; ls", ""},
+	}
+
+	for _, test := range tests {
+		cmd, err := cmds.Find(test.script, 0)
+		if err != nil {
+			t.Fatal("Expected command, got", err)
+		}
+		cmd.Section = test.section
+
+		page := ""
+		if name, section, err := (Man{}).Which(context.Background(), cmd); err == nil {
+			page = name + "(" + section + ")"
+		}
+		if page != test.page {
+			t.Errorf("%q in section %q: expected %q, got %q", test.script, test.section, test.page, page)
+		}
+	}
+}
+
+func TestExplicitSection(t *testing.T) {
+	cmd := &cmds.Command{Name: "printf", Section: "3"}
+	for _, provider := range []Provider{Help{}, Usage{}, Markdown{Dir: "testdata"}} {
+		if _, err := provider.Get(context.Background(), cmd, 80); err != ErrNoPage {
+			t.Errorf("%s: expected no page for an explicit section, got %v", provider.Name(), err)
+		}
+	}
+}
+
+func TestCompressedPage(t *testing.T) {
+	fakeManPath(t)
+
+	page, err := (Man{}).Get(context.Background(), &cmds.Command{Name: "printf"}, 80)
+	if err != nil {
+		t.Fatal("Expected page, got", err)
+	}
+	if title := strings.Fields(string(Plain(page)))[0]; title != "PAGE(1)" {
+		t.Error("Expected the page of printf.1.gz, got", title)
+	}
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	fakeManPath(t)
+	dir := os.Getenv("MANPATH")
+	for _, page := range []string{"man1/ls.1.xz", "man1/ls.1.zst", "man8/ls.8", "man1/printf.1.Z"} {
+		path := filepath.Join(dir, page)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(".TH PAGE 8\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		page string
+	}{
+		{"ls", "ls(8)"},
+		{"printf", "printf(1)"},
+	}
+
+	for _, test := range tests {
+		cmd := &cmds.Command{Name: test.name}
+		page := ""
+		if name, section, err := (Man{}).Which(context.Background(), cmd); err == nil {
+			page = name + "(" + section + ")"
+		}
+		if page != test.page {
+			t.Errorf("%s: expected %q, got %q", test.name, test.page, page)
+		}
+		if _, err := (Man{}).Get(context.Background(), cmd, 80); err != nil {
+			t.Errorf("%s: expected a page, got %v", test.name, err)
+		}
+	}
+}
+
+func TestPagesListedOnce(t *testing.T) {
+	fakeManPath(t)
+	dir := filepath.Join(os.Getenv("MANPATH"), "man1")
+
+	if pages := findPages("printf", ""); len(pages) != 2 {
+		t.Fatal("Expected two pages of printf, got", pages)
+	}
+	listing := listings[dir]
+	if listing == nil {
+		t.Fatal("Expected the listing of man1 to be kept")
+	}
+	findPages("git", "")
+	if listings[dir] != listing {
+		t.Error("Expected the listing of man1 to be reused")
+	}
+
+	// A page added to the directory changes its modification time
+	later := time.Now().Add(time.Hour)
+	if err := ioutil.WriteFile(filepath.Join(dir, "ls.1"), []byte(".TH LS 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(dir, later, later)
+	if pages := findPages("ls", ""); len(pages) != 1 || listings[dir] == listing {
+		t.Error("Expected man1 to be listed again with ls.1, got", pages)
+	}
+}
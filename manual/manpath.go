@@ -0,0 +1,299 @@
+package manual
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultManPath lists the directories that hold manual pages when MANPATH
+// doesn't say otherwise.
+var defaultManPath = []string{"/usr/local/share/man", "/usr/share/man", "/usr/local/man", "/usr/man"}
+
+// sectionOrder is the order man looks through the sections in. Sections that
+// aren't listed come after these.
+var sectionOrder = []string{"1", "n", "l", "8", "3", "0", "2", "5", "4", "9", "6", "7"}
+
+// compressions lists the extensions of compressed manual pages.
+var compressions = []string{".gz", ".bz2", ".xz", ".lzma", ".zst", ".Z"}
+
+// unsupported lists the extensions of compressed manual pages that can't be
+// read, which are passed over for the next page.
+var unsupported = map[string]bool{".xz": true, ".lzma": true, ".zst": true, ".Z": true}
+
+// dirListing is the listing of a directory along with its modification time
+// when it was read, which changes when files are added or removed.
+type dirListing struct {
+	modTime time.Time
+	entries []os.FileInfo // sorted by name
+}
+
+// manPathCache is the result of manPath for the environment it was made in.
+type manPathCache struct {
+	env  string // MANPATH and PATH
+	dirs []string
+}
+
+// Directories are listed again only if they have changed, as reading the
+// sections on every lookup is slow.
+var (
+	listingsMu  sync.Mutex
+	listings    = map[string]*dirListing{}
+	manPathMemo *manPathCache
+)
+
+// readDir returns the entries of a directory sorted by name, reusing the
+// last listing of the directory if it hasn't changed since.
+func readDir(dir string) []os.FileInfo {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+
+	listingsMu.Lock()
+	listing := listings[dir]
+	listingsMu.Unlock()
+	if listing != nil && listing.modTime.Equal(info.ModTime()) {
+		return listing.entries
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	listingsMu.Lock()
+	listings[dir] = &dirListing{modTime: info.ModTime(), entries: entries}
+	listingsMu.Unlock()
+
+	return entries
+}
+
+// manPath returns the directories that hold manual pages. MANPATH is used if
+// it is set, with an empty entry standing for the default directories, which
+// are the standard ones and the man directories next to those in PATH. The
+// directories are kept until MANPATH or PATH changes.
+func manPath() []string {
+	env := os.Getenv("MANPATH") + "\x00" + os.Getenv("PATH")
+	listingsMu.Lock()
+	memo := manPathMemo
+	listingsMu.Unlock()
+	if memo != nil && memo.env == env {
+		return memo.dirs
+	}
+
+	var defaults []string
+	for _, dir := range defaultManPath {
+		defaults = append(defaults, dir)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" {
+			parent := filepath.Dir(filepath.Clean(dir))
+			defaults = append(defaults, filepath.Join(parent, "share", "man"), filepath.Join(parent, "man"))
+		}
+	}
+
+	var dirs []string
+	if manpath := os.Getenv("MANPATH"); manpath != "" {
+		for _, dir := range strings.Split(manpath, ":") {
+			if dir == "" {
+				dirs = append(dirs, defaults...)
+			} else {
+				dirs = append(dirs, dir)
+			}
+		}
+	} else {
+		dirs = defaults
+	}
+
+	var existing []string
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() && !seen[dir] {
+			existing = append(existing, dir)
+			seen[dir] = true
+		}
+	}
+
+	listingsMu.Lock()
+	manPathMemo = &manPathCache{env: env, dirs: existing}
+	listingsMu.Unlock()
+
+	return existing
+}
+
+// sectionRank returns the position of a section in the order man looks
+// through them.
+func sectionRank(section string) int {
+	for i, s := range sectionOrder {
+		if s == section {
+			return i
+		}
+	}
+
+	return len(sectionOrder)
+}
+
+// findPages returns the files of the manual pages with the given name, in the
+// order man looks through them. If section isn't "", only the pages in that
+// section are returned.
+func findPages(name, section string) []string {
+	if name == "" || strings.ContainsAny(name, "/\x00") || strings.HasPrefix(name, ".") {
+		return nil
+	}
+
+	// Directories like man1 hold the pages of a section, including ones with
+	// a suffix such as 1ssl
+	type sectionDir struct {
+		path    string
+		section string
+		rank    int
+	}
+	var dirs []sectionDir
+	for i, root := range manPath() {
+		for _, entry := range readDir(root) {
+			dirSection := strings.TrimPrefix(entry.Name(), "man")
+			if !entry.IsDir() || dirSection == entry.Name() || dirSection == "" {
+				continue
+			}
+			if section != "" && !strings.HasPrefix(section, dirSection) {
+				continue
+			}
+			rank := sectionRank(dirSection)*1000 + i
+			dirs = append(dirs, sectionDir{filepath.Join(root, entry.Name()), dirSection, rank})
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].rank < dirs[j].rank })
+
+	var pages []string
+	for _, dir := range dirs {
+		// The files of the pages with the name are together in the listing
+		entries := readDir(dir.path)
+		first := sort.Search(len(entries), func(i int) bool { return entries[i].Name() >= name+"." })
+		var exact, suffixed []string
+		for _, entry := range entries[first:] {
+			if !strings.HasPrefix(entry.Name(), name+".") {
+				break
+			}
+			ext := pageSection(entry.Name(), name)
+			switch {
+			case unsupported[filepath.Ext(entry.Name())]:
+			case ext == "" || !strings.HasPrefix(ext, dir.section):
+			case section != "" && !strings.HasPrefix(ext, section):
+			case ext == dir.section || ext == section:
+				exact = append(exact, filepath.Join(dir.path, entry.Name()))
+			default:
+				suffixed = append(suffixed, filepath.Join(dir.path, entry.Name()))
+			}
+		}
+		pages = append(append(pages, exact...), suffixed...)
+	}
+
+	return pages
+}
+
+// pageSection returns the section in the file name of a manual page with the
+// given name, such as 1ssl for openssl-req.1ssl.gz, or "" if the file isn't
+// for a page with that name.
+func pageSection(file, name string) string {
+	base := trimCompression(file)
+	if !strings.HasPrefix(base, name+".") {
+		return ""
+	}
+	ext := base[len(name)+1:]
+	if strings.ContainsRune(ext, '.') {
+		return ""
+	}
+
+	return ext
+}
+
+// trimCompression removes the extension of a compressed file from its name.
+func trimCompression(file string) string {
+	for _, ext := range compressions {
+		if strings.HasSuffix(file, ext) {
+			return strings.TrimSuffix(file, ext)
+		}
+	}
+
+	return file
+}
+
+// readPage reads the source of a manual page, uncompressing it and following
+// .so requests that make it an alias of another page.
+func readPage(path string) ([]byte, error) {
+	for redirects := 0; redirects < 5; redirects++ {
+		src, err := readCompressed(path)
+		if err != nil {
+			return nil, err
+		}
+
+		target := soTarget(src)
+		if target == "" {
+			return src, nil
+		}
+		// The target is relative to the directory above the section directories
+		root := filepath.Dir(filepath.Dir(path))
+		path = filepath.Join(root, target)
+		if _, err := os.Stat(path); err != nil {
+			for _, ext := range compressions {
+				if _, err := os.Stat(path + ext); err == nil && !unsupported[ext] {
+					path += ext
+					break
+				}
+			}
+		}
+	}
+
+	return nil, errors.New("too many .so redirections")
+}
+
+// readCompressed reads a file that can be compressed.
+func readCompressed(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	switch filepath.Ext(path) {
+	case ".gz":
+		if reader, err = gzip.NewReader(file); err != nil {
+			return nil, err
+		}
+	case ".bz2":
+		reader = bzip2.NewReader(file)
+	default:
+		if unsupported[filepath.Ext(path)] {
+			return nil, errors.New("unsupported compression of " + path)
+		}
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+// soTarget returns the page named by a .so request at the start of the
+// source of a page, or "" if there is none.
+func soTarget(src []byte) string {
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		text := strings.TrimSpace(string(line))
+		switch {
+		case text == "" || strings.HasPrefix(text, `.\"`) || strings.HasPrefix(text, `'\"`):
+			continue
+		case strings.HasPrefix(text, ".so "):
+			return strings.TrimSpace(text[4:])
+		}
+		break
+	}
+
+	return ""
+}
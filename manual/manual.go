@@ -1,77 +1,330 @@
 package manual
 
 import (
-	"errors"
+	"bytes"
+	"context"
 	"os/exec"
 	"regexp"
-	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/bryce/bashly/cmds"
-	"github.com/youtube/vitess/go/cache"
 )
 
 // Page holds data associated with a manual page
 type Page []byte
 
-// Size gets the size of the manual page as used in the cache.
-func (p Page) Size() int {
-	return 1
+// builtins lists the bash builtins, which are documented by help rather than
+// by manual pages.
+var builtins = map[string]bool{
+	".": true, ":": true, "[": true, "alias": true, "bg": true, "bind": true, "break": true, "builtin": true,
+	"caller": true, "cd": true, "command": true, "compgen": true, "complete": true, "compopt": true,
+	"continue": true, "declare": true, "dirs": true, "disown": true, "echo": true, "enable": true, "eval": true,
+	"exec": true, "exit": true, "export": true, "false": true, "fc": true, "fg": true, "getopts": true,
+	"hash": true, "help": true, "history": true, "jobs": true, "kill": true, "let": true, "local": true,
+	"logout": true, "mapfile": true, "popd": true, "printf": true, "pushd": true, "pwd": true, "read": true,
+	"readarray": true, "readonly": true, "return": true, "set": true, "shift": true, "shopt": true,
+	"source": true, "suspend": true, "test": true, "times": true, "trap": true, "true": true, "type": true,
+	"typeset": true, "ulimit": true, "umask": true, "unalias": true, "unset": true, "wait": true,
 }
 
-var pageCache = cache.NewLRUCache(10)
+// validName matches the names of commands and subcommands that are looked
+// up. Names can't have shell metacharacters or path separators, or start
+// with a dash, so a name taken from a script is never run as code or taken
+// as an option.
+var validName = regexp.MustCompile(`^[\p{L}\p{N}_.:+@%,\[\]][\p{L}\p{N}_.:+@%,\[\]-]*$`)
 
-// Get returns the manual page for a given command.
-func Get(command *cmds.Command, width int) (Page, error) {
-	key := command.Name + string(width)
-	if val, ok := pageCache.Get(key); ok {
-		return val.(Page), nil
+// validCommand reports whether documentation can be looked up for a command.
+// Reserved words come from the parser, so they are always valid.
+func validCommand(command *cmds.Command) bool {
+	if command.Kind == cmds.Reserved {
+		return true
 	}
-
-	man := exec.Command("/bin/bash", "-c", "export MANWIDTH="+strconv.Itoa(width)+"; man "+command.Name)
-	bytes, err := man.Output()
-	if err != nil {
-		return nil, errors.New("No manual page found")
+	for _, name := range append([]string{command.Name}, command.Subcommands...) {
+		if !validName.MatchString(name) {
+			return false
+		}
 	}
 
-	page := Page(bytes)
-	pageCache.Set(key, page)
-	return page, nil
+	return true
+}
+
+// Get returns the manual page for a given command from the first provider of
+// the default registry that has one, along with that provider.
+func Get(ctx context.Context, command *cmds.Command, width int) (Page, Provider, error) {
+	return DefaultRegistry.Get(ctx, command, width)
 }
 
 // GetOptions returns the sections of the manual page for a given command
-// that have the description for the current options.
-func GetOptions(command *cmds.Command, width int) (Page, error) {
-	page, err := Get(command, width)
+// that have the description for the current options, or a line for those it
+// doesn't describe, along with the provider of the page.
+func GetOptions(ctx context.Context, command *cmds.Command, width int) (Page, Provider, error) {
+	return DefaultRegistry.GetOptions(ctx, command, width)
+}
+
+// Help provides the help from bash for reserved words and builtins.
+type Help struct{}
+
+// Name returns the name of the provider.
+func (Help) Name() string {
+	return "help"
+}
+
+// Layout returns the layout of the options in bash help.
+func (Help) Layout() Layout {
+	return HelpLayout
+}
+
+// Get returns the help for a command if it is a reserved word or a builtin,
+// and no manual section is asked for.
+func (Help) Get(ctx context.Context, command *cmds.Command, width int) (Page, error) {
+	if command.Section != "" || command.Kind != cmds.Reserved && !isBuiltin(command) || !validCommand(command) {
+		return nil, ErrNoPage
+	}
+
+	return help(ctx, command.Name)
+}
+
+// isBuiltin reports whether a command runs a bash builtin.
+func isBuiltin(command *cmds.Command) bool {
+	return !runByWrapper(command) && builtins[command.Name]
+}
+
+// runByWrapper reports whether a command is run by a wrapper other than time,
+// which runs the program of that name rather than a builtin or a function.
+func runByWrapper(command *cmds.Command) bool {
+	for _, wrapper := range command.Wrappers {
+		if wrapper.Name != "time" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// help returns the help for a reserved word or builtin from bash.
+func help(ctx context.Context, name string) (Page, error) {
+	// The help changes with the version of bash
+	source := "/bin/bash"
+	key := cacheKey{Provider: "help", Name: name}
+	if page, ok := pageCache.get(key, source); ok {
+		return page, nil
+	}
+
+	// The name is passed as an argument rather than put in the script
+	help := exec.CommandContext(ctx, "/bin/bash", "-c", `help -m -- "$1"`, "help", name)
+	bytes, err := help.Output()
 	if err != nil {
 		return nil, err
 	}
+
+	page := Page(bytes)
+	pageCache.set(key, source, page)
+	return page, nil
+}
+
+// options returns the sections of a page with the given layout that have the
+// description for the options. Options the page doesn't describe are listed
+// as undocumented, with the documented option they are likely a typo of.
+func options(page Page, layout Layout, command *cmds.Command) Page {
 	optionsPage := []byte{}
+	// Options are matched at the start of a line, including the first one
+	page = append([]byte{'\n'}, page...)
+	var longFlags []string
 
 	for _, opt := range command.Options {
+		flag := opt.Flag
 		// Empty option (hanging - or --)
-		if len(opt) <= 1 ||
-			opt[1] == '-' && len(opt) <= 2 {
+		if len(flag) <= 1 ||
+			flag[1] == '-' && len(flag) <= 2 {
 			continue
 		}
 
 		// Handle long option
-		if opt[:2] == "--" {
-			re, _ := regexp.Compile(`\n([ ]{7}([^ ].*?)?` + string(opt) + `.*?(\n|[ ]{8,}.*?\n)+)`)
-			matches := re.FindAllSubmatch(page, -1)
-			if len(matches) == 1 {
-				optionsPage = append(optionsPage, matches[0][1]...)
+		if flag[:2] == "--" {
+			re, _ := regexp.Compile(`\n(` + layout.Option + `([^ ].*?)?` + regexp.QuoteMeta(flag) + `.*?(\n|` + layout.More + `.*?\n)+)`)
+			if description := bestMatch(re.FindAllSubmatch(page, -1), layout); description != nil {
+				optionsPage = append(optionsPage, withValue(description, opt.Value)...)
+			} else {
+				if longFlags == nil {
+					longFlags = documentedFlags(page, layout)
+				}
+				optionsPage = append(optionsPage, withValue(undocumented(flag, suggest(flag, longFlags)), opt.Value)...)
 			}
 		} else {
-			// Handle short option
-			for i := 1; i < len(opt); i++ {
-				re, _ := regexp.Compile(`\n([ ]{7}-` + string(opt[i]) + `.*?(\n|[ ]{8,}.*?\n)+)`)
-				match := re.FindSubmatch(page)
-				if match != nil {
-					optionsPage = append(optionsPage, match[1]...)
+			// Handle short option, the value belongs to the last one in a group
+			flags, value := shortFlags(command, flag)
+			if value == "" {
+				value = opt.Value
+			}
+			if flags == nil {
+				// Digits are mostly values, such as the signal in kill -9, but
+				// are options to some commands, such as ls -1
+				if description := shortOption(page, layout, flag); description != nil {
+					optionsPage = append(optionsPage, withValue(description, opt.Value)...)
+				}
+				continue
+			}
+			for i, short := range flags {
+				description := shortOption(page, layout, short)
+				if description == nil {
+					description = undocumented(short, "")
 				}
+				if i == len(flags)-1 {
+					optionsPage = append(optionsPage, withValue(description, value)...)
+				} else {
+					optionsPage = append(optionsPage, description...)
+				}
+			}
+		}
+	}
+
+	return optionsPage
+}
+
+// bestMatch returns the description of a long option from the lines of a
+// page that have it, which is the first line that starts with an option, or
+// nil if it is only mentioned, such as in a synopsis.
+func bestMatch(matches [][][]byte, layout Layout) []byte {
+	entry := regexp.MustCompile(`^` + layout.Option + `-`)
+	for _, match := range matches {
+		if entry.Match(match[1]) {
+			return match[1]
+		}
+	}
+
+	return nil
+}
+
+// shortOption returns the description of a short option in a page with the
+// given layout, or nil if the page doesn't describe it.
+func shortOption(page Page, layout Layout, flag string) []byte {
+	re, _ := regexp.Compile(`\n(` + layout.Option + regexp.QuoteMeta(flag) + `.*?(\n|` + layout.More + `.*?\n)+)`)
+	if match := re.FindSubmatch(page); match != nil {
+		return match[1]
+	}
+
+	return nil
+}
+
+// shortFlags splits a group of short options, such as -la, into its flags,
+// along with the value attached to the last one, such as 5 in -n5. The value
+// starts after a flag that the command takes an argument for, or at a digit
+// after a flag. A group of only digits, such as -9, is a value with no flags.
+func shortFlags(command *cmds.Command, group string) ([]string, string) {
+	runes := []rune(group[1:])
+	if strings.Trim(group[1:], "0123456789") == "" {
+		return nil, group[1:]
+	}
+
+	var flags []string
+	for i, r := range runes {
+		if i > 0 && unicode.IsDigit(r) {
+			return flags, string(runes[i:])
+		}
+		flags = append(flags, "-"+string(r))
+		if i < len(runes)-1 && command.TakesArg("-"+string(r)) {
+			return flags, string(runes[i+1:])
+		}
+	}
+
+	return flags, ""
+}
+
+// undocumented returns the line for an option that a page doesn't describe,
+// suggesting another option if it isn't "".
+func undocumented(flag, suggestion string) []byte {
+	line := flag + "  undocumented option"
+	if suggestion != "" {
+		line += ", did you mean " + suggestion + "?"
+	}
+
+	return []byte(line + "\n")
+}
+
+// longFlag matches a long option, without its value.
+var longFlag = regexp.MustCompile(`--[[:alnum:]][[:alnum:]_-]*`)
+
+// documentedFlags returns the long options described in a page with the
+// given layout, in the order they are described.
+func documentedFlags(page Page, layout Layout) []string {
+	re := regexp.MustCompile(`\n` + layout.Option + `(-[^\n]*)`)
+	flags := []string{}
+	seen := map[string]bool{}
+	for _, match := range re.FindAllSubmatch(page, -1) {
+		for _, flag := range longFlag.FindAll(match[1], -1) {
+			if !seen[string(flag)] {
+				seen[string(flag)] = true
+				flags = append(flags, string(flag))
+			}
+		}
+	}
+
+	return flags
+}
+
+// suggest returns the flag closest to a mistyped one, or "" if none is close
+// enough to be what was meant. Ties go to the flag that comes first.
+func suggest(flag string, flags []string) string {
+	// Allow a typo or two, but fewer in short flags
+	best, bestDistance := "", len([]rune(flag))/3+1
+	if bestDistance > 3 {
+		bestDistance = 3
+	}
+	for _, candidate := range flags {
+		if d := distance(flag, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	return best
+}
+
+// distance returns the number of insertions, deletions, substitutions and
+// swaps of adjacent runes that turn one string into the other.
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
 			}
 		}
 	}
 
-	return optionsPage, nil
+	return d[len(s)][len(t)]
+}
+
+// withValue adds the value given to an option to the end of the first line of
+// its description.
+func withValue(description []byte, value string) []byte {
+	if value == "" {
+		return description
+	}
+
+	i := bytes.IndexByte(description, '\n')
+	withValue := append([]byte{}, description[:i]...)
+	withValue = append(withValue, "  = "+value...)
+
+	return append(withValue, description[i:]...)
 }
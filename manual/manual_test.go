@@ -0,0 +1,292 @@
+package manual
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// fakeProvider provides the fixture pages in testdata, named after the
+// command and the extension of the provider.
+type fakeProvider struct {
+	name   string
+	ext    string
+	layout Layout
+}
+
+func (p fakeProvider) Name() string {
+	return p.name
+}
+
+func (p fakeProvider) Layout() Layout {
+	return p.layout
+}
+
+func (p fakeProvider) Get(ctx context.Context, command *cmds.Command, width int) (Page, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join("testdata", strings.ReplaceAll(command.FullName(), " ", "-")+p.ext))
+	if err != nil {
+		return nil, ErrNoPage
+	}
+
+	return Page(bytes), nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{}
+	r.Register(fakeProvider{"usage", ".usage", UsageLayout}, 40)
+	r.Register(fakeProvider{"man", ".man", ManLayout}, 20)
+	r.Register(fakeProvider{"help", ".help", HelpLayout}, 10)
+	r.Register(fakeProvider{"man again", ".man", ManLayout}, 20)
+
+	names := []string{}
+	for _, provider := range r.Providers() {
+		names = append(names, provider.Name())
+	}
+	if strings.Join(names, "|") != "help|man|man again|usage" {
+		t.Error("Expected providers in order of priority, got", names)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+	}{
+		{"read", "help"},
+		{"ls", "man"},
+		{"grep", "usage"},
+	}
+
+	for _, test := range tests {
+		page, provider, err := r.Get(context.Background(), &cmds.Command{Name: test.name}, 80)
+		if err != nil || len(page) == 0 || provider.Name() != test.provider {
+			t.Errorf("%s: expected page from %s, got %v, %v", test.name, test.provider, provider, err)
+		}
+	}
+
+	if _, provider, err := r.Get(context.Background(), &cmds.Command{Name: "missing"}, 80); err != ErrNoPage || provider != nil {
+		t.Error("Expected ErrNoPage, got", provider, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := r.Get(ctx, &cmds.Command{Name: "ls"}, 80); err != context.Canceled {
+		t.Error("Expected the load to be cancelled, got", err)
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	r := &Registry{}
+	r.Register(fakeProvider{"help", ".help", HelpLayout}, 10)
+	r.Register(fakeProvider{"man", ".man", ManLayout}, 20)
+	r.Register(fakeProvider{"usage", ".usage", UsageLayout}, 30)
+	r.Register(Markdown{Dir: "testdata"}, 40)
+
+	tests := []struct {
+		script  string
+		options []string
+	}{
+		{"ls -la\n", []string{"       -l     use a long listing format\n", "       -a, --all\n"}},
+		{"ls --all --width=80\n", []string{"       -a, --all\n", "       -w, --width=COLS  = 80\n"}},
+		{"ls --color\n", []string{"       --color[=WHEN]\n              colorize  the output;"}},
+		{"ls -Z\n", []string{"-Z  undocumented option\n"}},
+		{"ls -lZ\n", []string{"       -l     use a long listing format\n", "-Z  undocumented option\n"}},
+		{"ls --lal\n", []string{"--lal  undocumented option, did you mean --all?\n"}},
+		{"ls --widht=80\n", []string{"--widht  undocumented option, did you mean --width?  = 80\n"}},
+		{"ls --zebra\n", []string{"--zebra  undocumented option\n"}},
+		{"ls -\n", nil},
+		{"ls -w80\n", []string{"       -w, --width=COLS  = 80\n"}},
+		{"ls -5\n", nil},
+		{"ls -1\n", []string{"       -1     list one file per line\n"}},
+		{"ls -lé\n", []string{"       -l     use a long listing format\n", "-é  undocumented option\n"}},
+		{"git -C /tmp commit -C HEAD\n", []string{"       -C <path>  = /tmp\n              Run as if git",
+			"       -C <commit>, --reuse-message=<commit>  = HEAD\n"}},
+		{"grep -im2 x\n", []string{"  -i, --ignore-case", "  -m, --max-count=NUM       stop after NUM selected lines  = 2\n"}},
+		{"read -r -p prompt\n", []string{"      -r\tdo not allow", "      -p prompt\toutput the string PROMPT without a trailing newline before  = prompt\n    \t\tattempting to read\n"}},
+		{"grep -i --max-count=2 x\n", []string{"  -i, --ignore-case", "  -m, --max-count=NUM       stop after NUM selected lines  = 2\n"}},
+		{"deploy -f --env=prod\n", []string{"- `-f`, `--force`: skip the confirmation\n", "- `-e`, `--env`: environment to deploy to, such as staging  = prod\n  or production\n"}},
+	}
+
+	for _, test := range tests {
+		cmd, err := cmds.Find(test.script, 0)
+		if err != nil {
+			t.Fatal("Expected command, got", err)
+		}
+
+		page, _, err := r.GetOptions(context.Background(), cmd, 80)
+		if err != nil {
+			t.Errorf("%q: expected options, got error %v", test.script, err)
+			continue
+		}
+		for _, option := range test.options {
+			if !strings.Contains(string(page), option) {
+				t.Errorf("%q: expected %q in %q", test.script, option, page)
+			}
+		}
+		if test.options == nil && len(page) > 0 {
+			t.Errorf("%q: expected no options, got %q", test.script, page)
+		}
+	}
+}
+
+func TestIsBuiltin(t *testing.T) {
+	tests := []struct {
+		script  string
+		builtin bool
+	}{
+		{"read -r x\n", true},
+		{"time cd /tmp\n", true},
+		{"sudo echo x\n", false},
+		{"/bin/echo x\n", false},
+		{"ls\n", false},
+	}
+
+	for _, test := range tests {
+		cmd, err := cmds.Find(test.script, len(test.script)-2)
+		if err != nil {
+			t.Fatal("Expected command, got", err)
+		}
+		if isBuiltin(cmd) != test.builtin {
+			t.Errorf("%q: expected %s to be builtin %v", test.script, cmd.Name, test.builtin)
+		}
+	}
+}
+
+func TestValidCommand(t *testing.T) {
+	tests := []struct {
+		command cmds.Command
+		valid   bool
+	}{
+		{cmds.Command{Name: "ls"}, true},
+		{cmds.Command{Name: "git", Subcommands: []string{"commit"}}, true},
+		{cmds.Command{Name: "g++"}, true},
+		{cmds.Command{Name: "python3.11"}, true},
+		{cmds.Command{Name: "["}, true},
+		{cmds.Command{Name: "[[", Kind: cmds.Reserved}, true},
+		{cmds.Command{Name: "größe"}, true},
+		{cmds.Command{Name: ""}, false},
+		{cmds.Command{Name: "-rf"}, false},
+		{cmds.Command{Name: "./deploy.sh"}, false},
+		{cmds.Command{Name: "foo;rm"}, false},
+		{cmds.Command{Name: "foo$(id)"}, false},
+		{cmds.Command{Name: "git", Subcommands: []string{"`id`"}}, false},
+	}
+
+	for _, test := range tests {
+		if validCommand(&test.command) != test.valid {
+			t.Errorf("%q %q: expected valid %v", test.command.Name, test.command.Subcommands, test.valid)
+		}
+	}
+}
+
+func TestHostileNames(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "pwned")
+	script := []byte("#!/bin/sh\ntouch " + marker + "\necho usage\n")
+
+	// Each name is also a program that gives itself away when it runs, and
+	// code run by a shell would create the marker in the working directory
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"foo;touch pwned", "foo$(touch pwned)", "`touch pwned`", "foo|touch pwned",
+		"foo&&touch pwned", "foo\ntouch pwned", "foo touch pwned", "-foo", "'foo'", "safe"}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), script, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("MANPATH", dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	commands := []*cmds.Command{{Name: "safe", Subcommands: []string{"$(touch pwned)"}}}
+	for _, name := range names[:len(names)-1] {
+		commands = append(commands, &cmds.Command{Name: name}, &cmds.Command{Name: name, Section: "1"})
+	}
+	providers := []Provider{Help{}, Man{}, Markdown{Dir: dir}, Usage{}}
+	for _, command := range commands {
+		if _, _, err := DefaultRegistry.Get(context.Background(), command, 80); err != ErrNoPage {
+			t.Errorf("%q: expected no page, got %v", command.Name, err)
+		}
+		for _, provider := range providers {
+			if _, err := provider.Get(context.Background(), command, 80); err != ErrNoPage {
+				t.Errorf("%q: expected no page from %s, got %v", command.Name, provider.Name(), err)
+			}
+		}
+		Sections(context.Background(), command.Name)
+	}
+	// Programs are only run for their usage when that is enabled
+	if _, _, err := DefaultRegistry.Get(context.Background(), &cmds.Command{Name: "safe"}, 80); err != ErrNoPage {
+		t.Error("Expected no page for safe by default, got", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("Expected no hostile name to run")
+	}
+
+	// A safe name does run, so the marker would show the others running
+	if _, err := (Usage{}).Get(context.Background(), &cmds.Command{Name: "safe"}, 80); err != nil {
+		t.Fatal("Expected the usage of safe, got", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("Expected safe to run")
+	}
+}
+
+func TestOptionsMatchedTwice(t *testing.T) {
+	page := "SYNOPSIS\n       ls [--all] [--width=COLS] [--color]\n\n" +
+		"OPTIONS\n       -a, --all\n              do not ignore entries\n" +
+		"       --colour\n              colorize the output\n"
+
+	tests := []struct {
+		opt      cmds.Option
+		expected string
+	}{
+		// The entry of the option rather than the synopsis
+		{cmds.Option{Flag: "--all"}, "       -a, --all\n              do not ignore entries\n"},
+		// Only in the synopsis
+		{cmds.Option{Flag: "--width", Value: "80"}, string(withValue(undocumented("--width", ""), "80"))},
+		{cmds.Option{Flag: "--color"}, string(undocumented("--color", "--colour"))},
+	}
+
+	for _, test := range tests {
+		opts := options(Page(page), ManLayout, &cmds.Command{Options: []cmds.Option{test.opt}})
+		if string(opts) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.opt.Flag, test.expected, opts)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	flags := []string{"--exclude", "--exclude-from", "--include", "--all"}
+
+	tests := []struct {
+		flag       string
+		suggestion string
+	}{
+		{"--exlude", "--exclude"},
+		{"--exculde", "--exclude"},
+		{"--inclde", "--include"},
+		{"--exclude-form", "--exclude-from"},
+		{"--al", "--all"},
+		{"--a", ""},
+		{"--verbose", ""},
+	}
+
+	for _, test := range tests {
+		if suggestion := suggest(test.flag, flags); suggestion != test.suggestion {
+			t.Errorf("%s: expected %q, got %q", test.flag, test.suggestion, suggestion)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package manual
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// Markdown provides local documentation written in markdown, such as for a
+// project's own scripts. The page for a command is the file named after it
+// in Dir, such as deploy.md, and subcommands are looked up like man pages,
+// such as deploy-rollback.md. Options are listed as items starting with the
+// option in backquotes:
+//
+//	Options:
+//	- `-f`, `--force`: skip the confirmation
+type Markdown struct {
+	Dir string
+}
+
+// Name returns the name of the provider.
+func (Markdown) Name() string {
+	return "markdown"
+}
+
+// Layout returns the layout of the options in markdown docs.
+func (Markdown) Layout() Layout {
+	return MarkdownLayout
+}
+
+// Get returns the markdown docs for a command.
+func (md Markdown) Get(ctx context.Context, command *cmds.Command, width int) (Page, error) {
+	if command.Section != "" || command.Kind == cmds.Reserved || !validCommand(command) {
+		return nil, ErrNoPage
+	}
+
+	for i := len(command.Subcommands); i >= 0; i-- {
+		name := strings.Join(append([]string{command.Name}, command.Subcommands[:i]...), "-")
+		if bytes, err := ioutil.ReadFile(filepath.Join(md.Dir, name+".md")); err == nil {
+			return Page(bytes), nil
+		}
+	}
+
+	return nil, ErrNoPage
+}
+
+// markdownDir returns the directory of the markdown docs, ~/.bashly/docs.
+func markdownDir() (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", errors.New("no home directory")
+	}
+
+	return filepath.Join(home, ".bashly", "docs"), nil
+}
@@ -0,0 +1,664 @@
+package manual
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// mdocState is the state of rendering the macros of mdoc(7).
+type mdocState struct {
+	name     string // name of the page, from the first .Nm
+	section  string // heading of the current section
+	lists    []*mdocList
+	displays []mdocDisplay
+	noSpace  bool // whether words are joined, after .Sm off
+	spaced   bool // whether a word has been emitted since .Sm off
+	xo       bool // whether the tag of a list item goes on, after Xo
+	xoBody   int  // indentation of the body of that list item
+	fo       int  // arguments of the function started by .Fo, -1 outside one
+	refs     []string
+	split    bool // whether authors go on lines of their own, after .An -split
+	authors  bool // whether an author has been named
+}
+
+// mdocList is a list started by .Bl.
+type mdocList struct {
+	kind    string // such as tag or bullet
+	width   int    // indentation of the bodies of items
+	base    int    // indentation of items
+	compact bool
+	indent  int // indentation before the list
+	items   int
+	columns []int
+}
+
+// mdocDisplay is a display started by .Bd.
+type mdocDisplay struct {
+	indent int
+	fill   bool
+}
+
+// mdocCallable are the macros of mdoc(7) that can be called in the arguments
+// of others.
+var mdocCallable = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`Ad An Ap Ar At Bc Bo Bq Brc Bro Brq
+		Bsx Bx Cd Cm Dc Do Dq Dv Dx Ec Em Eo Er Ev Fa Fc Fl Fn Fo Ft Fx Ic In
+		Lk Li Ms Mt Nm No Ns Nx Oc Oo Op Ot Ox Pa Pc Pf Po Pq Qc Ql Qo Qq Sc
+		Sm So Sq St Sx Sy Ta Tn Ux Va Vt Xc Xo Xr`) {
+		mdocCallable[name] = true
+	}
+}
+
+// mdocEnclosures are the delimiters put around the arguments of macros.
+var mdocEnclosures = map[string][2]string{
+	"Op": {"[", "]"}, "Oo": {"[", "]"}, "Oc": {"[", "]"},
+	"Bq": {"[", "]"}, "Bo": {"[", "]"}, "Bc": {"[", "]"},
+	"Pq": {"(", ")"}, "Po": {"(", ")"}, "Pc": {"(", ")"},
+	"Brq": {"{", "}"}, "Bro": {"{", "}"}, "Brc": {"{", "}"},
+	"Aq": {"⟨", "⟩"}, "Ao": {"⟨", "⟩"}, "Ac": {"⟨", "⟩"},
+	"Dq": {"“", "”"}, "Do": {"“", "”"}, "Dc": {"“", "”"},
+	"Sq": {"‘", "’"}, "So": {"‘", "’"}, "Sc": {"‘", "’"},
+	"Qq": {`"`, `"`}, "Qo": {`"`, `"`}, "Qc": {`"`, `"`},
+	"Ql": {"‘", "’"},
+}
+
+// mdocFonts are the fonts of the arguments of macros.
+var mdocFonts = map[string]format{
+	"Cm": bold, "Ic": bold, "Sy": bold, "Fl": bold, "Nm": bold, "Fd": bold, "In": bold,
+	"Ar": underline, "Em": underline, "Pa": underline, "Va": underline, "Vt": underline,
+	"Ft": underline, "Fa": underline, "Ad": underline,
+}
+
+// mdocVolumes are the names of the manuals of sections.
+var mdocVolumes = map[string]string{
+	"1": "General Commands Manual", "2": "System Calls Manual", "3": "Library Functions Manual",
+	"4": "Device Drivers Manual", "5": "File Formats Manual", "6": "Games Manual",
+	"7": "Miscellaneous Information Manual", "8": "System Manager's Manual", "9": "Kernel Developer's Manual",
+}
+
+// mdocSystems are the names of the systems that macros like .Fx name.
+var mdocSystems = map[string]string{
+	"At": "AT&T UNIX", "Bsx": "BSD/OS", "Bx": "BSD", "Dx": "DragonFly", "Fx": "FreeBSD",
+	"Nx": "NetBSD", "Ox": "OpenBSD", "Ux": "UNIX",
+}
+
+// mdocStandards are the names of the standards that .St names.
+var mdocStandards = map[string]string{
+	"-p1003.1":      "IEEE Std 1003.1 (“POSIX.1”)",
+	"-p1003.1-88":   "IEEE Std 1003.1-1988 (“POSIX.1”)",
+	"-p1003.1-90":   "IEEE Std 1003.1-1990 (“POSIX.1”)",
+	"-p1003.1-96":   "ISO/IEC 9945-1:1996 (“POSIX.1”)",
+	"-p1003.1-2001": "IEEE Std 1003.1-2001 (“POSIX.1”)",
+	"-p1003.1-2004": "IEEE Std 1003.1-2004 (“POSIX.1”)",
+	"-p1003.1-2008": "IEEE Std 1003.1-2008 (“POSIX.1”)",
+	"-p1003.2":      "IEEE Std 1003.2 (“POSIX.2”)",
+	"-p1003.2-92":   "IEEE Std 1003.2-1992 (“POSIX.2”)",
+	"-xpg4":         "X/Open Portability Guide Issue 4 (“XPG4”)",
+	"-xpg4.2":       "X/Open Portability Guide Issue 4, Version 2 (“XPG4.2”)",
+	"-susv2":        "Version 2 of the Single UNIX Specification (“SUSv2”)",
+	"-susv3":        "Version 3 of the Single UNIX Specification (“SUSv3”)",
+	"-susv4":        "Version 4 of the Single UNIX Specification (“SUSv4”)",
+	"-ansiC":        "ANSI X3.159-1989 (“ANSI C89”)",
+	"-isoC":         "ISO/IEC 9899:1990 (“ISO C90”)",
+	"-isoC-99":      "ISO/IEC 9899:1999 (“ISO C99”)",
+	"-isoC-2011":    "ISO/IEC 9899:2011 (“ISO C11”)",
+}
+
+// isClosing returns whether an argument is a delimiter that goes right after
+// the word before it.
+func isClosing(arg string) bool {
+	switch arg {
+	case ".", ",", ":", ";", ")", "]", "?", "!":
+		return true
+	}
+	return false
+}
+
+// isOpening returns whether an argument is a delimiter that goes right before
+// the word after it.
+func isOpening(arg string) bool {
+	return arg == "(" || arg == "["
+}
+
+// mdoc renders a macro of mdoc(7) that is called at the start of a line,
+// returning false if it isn't one.
+func (r *roff) mdoc(name string, args []string, rest string) bool {
+	m := &r.mdocState
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	synopsis := m.section == "SYNOPSIS"
+
+	switch name {
+	case "Dd", "Os", "Bk", "Ek", "Tg":
+	case "Dt":
+		r.header(r.plainText(arg(0))+"("+r.plainText(arg(1))+")", mdocVolumes[arg(1)])
+	case "Sh", "Ss":
+		m.lists, m.displays, m.fo = nil, nil, -1
+		r.heading(name == "Ss", args)
+	case "Pp", "Lp":
+		r.blankLine()
+	case "Nd":
+		r.word([]cell{{'—', plain}})
+		r.phrase(args)
+	case "Nm":
+		if synopsis && len(m.lists) == 0 {
+			if m.name == "" {
+				m.name = arg(0)
+			}
+			display := m.name
+			if len(args) > 0 && !isClosing(args[0]) {
+				display = args[0]
+			}
+			r.flush()
+			r.indent, r.first = r.base+utf8.RuneCountInString(r.plainText(display))+1, r.base
+		}
+		r.phrase(append([]string{name}, args...))
+	case "Bl":
+		r.beginList(args)
+	case "It":
+		r.item(args, rest)
+	case "El":
+		r.flush()
+		if n := len(m.lists); n > 0 {
+			r.indent = m.lists[n-1].indent
+			m.lists = m.lists[:n-1]
+		}
+	case "Bd":
+		r.flush()
+		m.displays = append(m.displays, mdocDisplay{r.indent, r.fill})
+		compact := false
+		for i, a := range args {
+			switch a {
+			case "-literal", "-unfilled", "-code":
+				r.fill = false
+			case "-filled", "-ragged", "-centered":
+				r.fill = true
+			case "-offset":
+				r.indent += mdocOffset(arg(i + 1))
+			case "-compact":
+				compact = true
+			}
+		}
+		if !compact {
+			r.blankLine()
+		}
+	case "Ed":
+		r.flush()
+		if n := len(m.displays); n > 0 {
+			r.indent, r.fill = m.displays[n-1].indent, m.displays[n-1].fill
+			m.displays = m.displays[:n-1]
+		}
+	case "D1", "Dl":
+		r.flush()
+		indent, fill := r.indent, r.fill
+		r.indent += 6
+		r.fill = name == "D1"
+		r.phrase(args)
+		r.flush()
+		r.indent, r.fill = indent, fill
+	case "Bf":
+		r.font = map[string]format{"-emphasis": underline, "Em": underline, "-symbolic": bold, "Sy": bold}[arg(0)]
+	case "Ef":
+		r.font = plain
+	case "Rs":
+		m.refs = nil
+	case "%A", "%B", "%C", "%D", "%I", "%J", "%N", "%O", "%P", "%Q", "%R", "%T", "%U", "%V":
+		m.refs = append(m.refs, r.plainText(strings.Join(args, " ")))
+	case "Re":
+		cells, _ := r.parse(strings.Join(m.refs, ", ")+".", plain)
+		r.words(cells)
+		m.refs = nil
+	case "An":
+		switch arg(0) {
+		case "-split":
+			m.split = true
+		case "-nosplit":
+			m.split = false
+		default:
+			if m.split && m.authors {
+				r.flush()
+			}
+			m.authors = true
+			r.phrase(args)
+		}
+	case "Ex", "Rv":
+		names := args
+		if len(names) > 0 && names[0] == "-std" {
+			names = names[1:]
+		}
+		if len(names) == 0 {
+			names = []string{m.name}
+		}
+		if name == "Ex" {
+			r.phrase([]string{"No", "The"})
+			r.phrase(append([]string{"Nm"}, names...))
+			r.phrase(strings.Fields("No utility exits 0 on success, and >0 if an error occurs."))
+		} else {
+			r.phrase([]string{"No", "The"})
+			r.phrase([]string{"Fn", names[0]})
+			r.phrase(strings.Fields("No function returns the value 0 if successful; otherwise the value -1 is returned and the global variable"))
+			r.phrase([]string{"Va", "errno", "No", "is", "set", "to", "indicate", "the", "error", "."})
+		}
+	case "In":
+		if synopsis {
+			r.flush()
+		}
+		r.phrase([]string{"Sy", "#include", "<" + arg(0) + ">"})
+		if synopsis {
+			r.flush()
+		}
+	case "Fd":
+		r.flush()
+		r.phrase(append([]string{"Fd"}, args...))
+		r.flush()
+	case "Ft":
+		if synopsis {
+			r.blankLine()
+		}
+		r.phrase(append([]string{"Ft"}, args...))
+		if synopsis {
+			r.flush()
+		}
+	case "Fo":
+		r.phrase([]string{"Fn", arg(0)})
+		r.space = false
+		r.word([]cell{{'(', plain}})
+		r.space, m.fo = false, 0
+	case "Fc":
+		r.space = false
+		r.word([]cell{{')', plain}})
+		m.fo = -1
+		if synopsis {
+			r.space = false
+			r.word([]cell{{';', plain}})
+			r.flush()
+		}
+	case "Fn":
+		r.phrase(append([]string{name}, args...))
+		if synopsis {
+			r.space = false
+			r.word([]cell{{';', plain}})
+			r.flush()
+		}
+	default:
+		if !mdocCallable[name] {
+			return false
+		}
+		r.phrase(append([]string{name}, args...))
+	}
+
+	return true
+}
+
+// mdocWidth returns the width given by the -width of a list, which is a
+// length or a string as wide as the tags of the items.
+func mdocWidth(arg string) int {
+	switch {
+	case arg == "Ds":
+		return 6
+	case mdocCallable[arg]:
+		return 10
+	}
+	if width := number(arg, -1); width >= 0 {
+		return width
+	}
+
+	return utf8.RuneCountInString(arg) + 2
+}
+
+// mdocOffset returns the indentation given by the -offset of a list or a
+// display.
+func mdocOffset(arg string) int {
+	switch arg {
+	case "indent":
+		return 6
+	case "indent-two":
+		return 12
+	case "left", "center", "right", "":
+		return 0
+	}
+
+	return mdocWidth(arg)
+}
+
+// beginList starts a list of .Bl.
+func (r *roff) beginList(args []string) {
+	r.flush()
+	list := &mdocList{kind: "item", indent: r.indent}
+	width := -1
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; a {
+		case "-tag", "-hang", "-ohang", "-inset", "-bullet", "-dash", "-hyphen", "-enum", "-item", "-diag", "-column":
+			list.kind = a[1:]
+		case "-width":
+			if i+1 < len(args) {
+				width = mdocWidth(args[i+1])
+				i++
+			}
+		case "-offset":
+			if i+1 < len(args) {
+				list.base = mdocOffset(args[i+1])
+				i++
+			}
+		case "-compact":
+			list.compact = true
+		default:
+			if list.kind == "column" {
+				list.columns = append(list.columns, utf8.RuneCountInString(r.plainText(a)))
+			}
+		}
+	}
+
+	if width < 0 {
+		switch list.kind {
+		case "tag", "hang":
+			width = 8
+		case "enum":
+			width = 4
+		default:
+			width = 2
+		}
+	}
+	list.width = width
+	list.base += r.indent
+	r.mdocState.lists = append(r.mdocState.lists, list)
+}
+
+// item starts an item of the list of .Bl.
+func (r *roff) item(args []string, rest string) {
+	m := &r.mdocState
+	if len(m.lists) == 0 {
+		r.phrase(args)
+		return
+	}
+	list := m.lists[len(m.lists)-1]
+	list.items++
+
+	if list.compact {
+		r.flush()
+	} else {
+		r.blankLine()
+	}
+	r.indent, r.first = list.base, -1
+	body := list.base + list.width
+
+	switch list.kind {
+	case "tag", "hang":
+		m.xoBody = body
+		r.phrase(args)
+		if !m.xo {
+			r.endTag(body)
+		}
+	case "ohang":
+		r.phrase(args)
+		r.flush()
+	case "inset", "diag":
+		r.phrase(args)
+	case "bullet", "dash", "hyphen", "enum":
+		marker := map[string]string{"bullet": "•", "dash": "-", "hyphen": "-"}[list.kind]
+		if list.kind == "enum" {
+			marker = strconv.Itoa(list.items) + "."
+		}
+		cells, _ := r.parse(marker, plain)
+		r.word(cells)
+		r.endTag(body)
+	case "column":
+		// Columns are separated by tabs or Ta
+		var columns [][]string
+		for _, part := range strings.Split(rest, "\t") {
+			columns = append(columns, nil)
+			for _, a := range splitArgs(part) {
+				if a == "Ta" {
+					columns = append(columns, nil)
+				} else {
+					columns[len(columns)-1] = append(columns[len(columns)-1], a)
+				}
+			}
+		}
+		col := list.base
+		for k, column := range columns {
+			if r.line != nil {
+				r.pad(col)
+				r.space = r.col > col
+			}
+			r.phrase(column)
+			width := 10
+			if k < len(list.columns) {
+				width = list.columns[k]
+			}
+			col += width + 2
+		}
+		r.flush()
+	default:
+		r.indent = list.base
+	}
+}
+
+// phrase renders the arguments of a line of mdoc(7), in which macros can be
+// called. Words that aren't arguments of a macro are plain text.
+func (r *roff) phrase(tokens []string) {
+	m := &r.mdocState
+	for i := 0; i < len(tokens); {
+		macro := "No"
+		if mdocCallable[tokens[i]] {
+			macro = tokens[i]
+			i++
+		}
+
+		switch macro {
+		case "Op", "Dq", "Sq", "Qq", "Pq", "Bq", "Brq", "Aq", "Ql":
+			// These enclose the rest of the line, except closing delimiters
+			enclosed := tokens[i:]
+			end := len(enclosed)
+			for end > 0 && isClosing(enclosed[end-1]) {
+				end--
+			}
+			r.emit(mdocEnclosures[macro][0], plain)
+			r.space = false
+			r.phrase(enclosed[:end])
+			r.space = false
+			r.emit(mdocEnclosures[macro][1], plain)
+			for _, d := range enclosed[end:] {
+				r.delimiter(d)
+			}
+			return
+		case "Oo", "Bo", "Po", "Bro", "Ao", "Do", "So", "Qo":
+			r.emit(mdocEnclosures[macro][0], plain)
+			r.space = false
+			continue
+		case "Oc", "Bc", "Pc", "Brc", "Ac", "Dc", "Sc", "Qc":
+			r.space = false
+			r.emit(mdocEnclosures[macro][1], plain)
+			continue
+		case "Eo":
+			if i < len(tokens) && !mdocCallable[tokens[i]] {
+				r.emit(tokens[i], plain)
+				r.space = false
+				i++
+			}
+			continue
+		case "Ec":
+			r.space = false
+			if i < len(tokens) && !mdocCallable[tokens[i]] {
+				r.emit(tokens[i], plain)
+				i++
+			}
+			continue
+		case "Ns":
+			r.space = false
+			continue
+		case "Ap":
+			r.space = false
+			r.emit("'", plain)
+			r.space = false
+			continue
+		case "Sm":
+			if i < len(tokens) {
+				m.noSpace, m.spaced = tokens[i] == "off", false
+				i++
+			}
+			continue
+		case "Xo":
+			m.xo = true
+			continue
+		case "Xc":
+			if m.xo {
+				m.xo = false
+				r.endTag(m.xoBody)
+			}
+			continue
+		case "Ta":
+			r.space = true
+			continue
+		}
+
+		j := i
+		for j < len(tokens) && !mdocCallable[tokens[j]] {
+			j++
+		}
+		r.inline(macro, tokens[i:j])
+		i = j
+	}
+}
+
+// inline renders a macro that can be called in the arguments of others,
+// with the arguments it takes.
+func (r *roff) inline(macro string, args []string) {
+	m := &r.mdocState
+	font := mdocFonts[macro]
+
+	switch macro {
+	case "Fl":
+		if len(args) == 0 || isClosing(args[0]) {
+			r.emit("-", bold)
+			// Fl Fl name is --name
+			r.space = len(args) > 0
+		}
+		for _, a := range args {
+			if isClosing(a) || isOpening(a) || a == "|" {
+				r.delimiter(a)
+			} else {
+				r.emit(`\-`+a, bold)
+			}
+		}
+		return
+	case "Ar":
+		if len(args) == 0 || isClosing(args[0]) {
+			r.emit("file ...", underline)
+		}
+	case "Nm":
+		if m.name == "" && len(args) > 0 {
+			m.name = args[0]
+		}
+		if len(args) == 0 || isClosing(args[0]) {
+			r.emit(m.name, bold)
+		}
+	case "Xr":
+		if len(args) > 1 && !isClosing(args[1]) {
+			r.emit(args[0]+"("+args[1]+")", plain)
+			args = args[2:]
+		}
+	case "Fn":
+		if len(args) == 0 {
+			return
+		}
+		r.emit(args[0], bold)
+		r.space = false
+		r.emit("(", plain)
+		end := len(args)
+		for end > 1 && isClosing(args[end-1]) {
+			end--
+		}
+		for k, a := range args[1:end] {
+			r.space = false
+			if k > 0 {
+				r.emit(",", plain)
+			}
+			r.emit(a, underline)
+		}
+		r.space = false
+		r.emit(")", plain)
+		args = args[end:]
+	case "Fa":
+		if m.fo >= 0 {
+			for _, a := range args {
+				r.space = false
+				if m.fo > 0 {
+					r.emit(",", plain)
+				}
+				r.emit(a, underline)
+				m.fo++
+			}
+			return
+		}
+	case "Pf":
+		if len(args) > 0 {
+			r.emit(args[0], plain)
+			r.space = false
+			args = args[1:]
+		}
+	case "St":
+		if len(args) > 0 {
+			if standard, ok := mdocStandards[args[0]]; ok {
+				r.emit(standard, plain)
+				args = args[1:]
+			}
+		}
+	case "Bx", "Bsx", "Fx", "Nx", "Ox", "Dx", "At", "Ux":
+		system := mdocSystems[macro]
+		if len(args) > 0 && !isClosing(args[0]) && macro != "At" && macro != "Ux" {
+			if macro == "Bx" {
+				system = args[0] + system
+			} else {
+				system += " " + args[0]
+			}
+			args = args[1:]
+		}
+		r.emit(system, plain)
+	case "Lk":
+		if len(args) > 1 && !isClosing(args[1]) {
+			r.emit(args[1]+":", plain)
+			r.emit(args[0], plain)
+			args = args[2:]
+		}
+	}
+
+	for _, a := range args {
+		if isClosing(a) || isOpening(a) {
+			r.delimiter(a)
+		} else {
+			r.emit(a, font)
+		}
+	}
+}
+
+// emit renders a word of an mdoc(7) line in a font.
+func (r *roff) emit(word string, font format) {
+	cells, _ := r.parse(word, font)
+	// The space before the first word after .Sm off is kept, only the words
+	// after it are joined
+	if r.mdocState.noSpace && r.mdocState.spaced {
+		r.space = false
+	}
+	r.mdocState.spaced = true
+	r.words(cells)
+}
+
+// delimiter renders a delimiter, which sticks to the word before it if it is
+// closing and to the word after it if it is opening.
+func (r *roff) delimiter(d string) {
+	if isClosing(d) {
+		r.space = false
+	}
+	r.emit(d, plain)
+	if isOpening(d) {
+		r.space = false
+	}
+}
@@ -0,0 +1,138 @@
+package manual
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// ErrNoPage is the error returned when no provider has a page for a command.
+var ErrNoPage = errors.New("No manual page found")
+
+// Provider is a source of documentation for commands.
+type Provider interface {
+	// Name returns the name of the provider, such as man.
+	Name() string
+	// Layout returns the layout of the options in the pages of the provider.
+	Layout() Layout
+	// Get returns the page for a command, formatted for the given width, or
+	// ErrNoPage if the provider has no page for it. The page can have bold
+	// and underlined text, as overstrikes or escape sequences. Loading the page stops
+	// with the context's error if the context is done.
+	Get(ctx context.Context, command *cmds.Command, width int) (Page, error)
+}
+
+// Layout describes how the options are laid out in a page, as regular
+// expressions for the start of the line that describes an option and for the
+// lines that continue its description.
+type Layout struct {
+	Option, More string
+}
+
+// Layouts of the pages of the standard providers.
+var (
+	ManLayout      = Layout{`[ ]{7}`, `[ ]{8,}`}
+	HelpLayout     = Layout{`[ ]{6}`, `[ ]{4}\t\t`}
+	UsageLayout    = Layout{`[ ]{2,6}`, `[ ]{8,}`}
+	MarkdownLayout = Layout{"[*-] `", `[ ]{2,}`}
+)
+
+// Registry is a set of providers that are asked for pages in order of
+// priority, lowest first.
+type Registry struct {
+	providers  []Provider
+	priorities []int
+}
+
+// Register adds a provider to the registry. Providers with the same
+// priority are asked in the order they were registered.
+func (r *Registry) Register(provider Provider, priority int) {
+	i := sort.Search(len(r.priorities), func(i int) bool { return r.priorities[i] > priority })
+
+	r.providers = append(r.providers[:i], append([]Provider{provider}, r.providers[i:]...)...)
+	r.priorities = append(r.priorities[:i], append([]int{priority}, r.priorities[i:]...)...)
+}
+
+// Providers returns the providers in the order they are asked.
+func (r *Registry) Providers() []Provider {
+	return append([]Provider{}, r.providers...)
+}
+
+// Get returns the page for a command from the first provider that has one,
+// along with that provider. If the context is done first, its error is
+// returned. Commands with names that aren't safe to look up have no page.
+func (r *Registry) Get(ctx context.Context, command *cmds.Command, width int) (Page, Provider, error) {
+	if !validCommand(command) {
+		return nil, nil, ErrNoPage
+	}
+	for _, provider := range r.providers {
+		page, err := provider.Get(ctx, command, width)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err == nil {
+			return page, provider, nil
+		}
+	}
+
+	return nil, nil, ErrNoPage
+}
+
+// GetOptions returns the sections of the page for a command that have the
+// description for its current options, or a line for those it doesn't
+// describe, along with the provider of the page. Global options are looked up
+// in the page of the command without its subcommands.
+func (r *Registry) GetOptions(ctx context.Context, command *cmds.Command, width int) (Page, Provider, error) {
+	page, provider, err := r.Get(ctx, command, width)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Global options, such as -C in git -C dir commit, are described in the
+	// page of the command itself rather than that of its subcommands
+	own, base := *command, *command
+	own.Options, base.Options, base.Subcommands = nil, nil, nil
+	for _, opt := range command.Options {
+		if opt.Global && len(command.Subcommands) > 0 {
+			base.Options = append(base.Options, opt)
+		} else {
+			own.Options = append(own.Options, opt)
+		}
+	}
+
+	optionsPage := options(Plain(page), provider.Layout(), &own)
+	if len(base.Options) > 0 {
+		basePage, baseProvider, baseErr := r.Get(ctx, &base, width)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if baseErr != nil {
+			basePage, baseProvider = nil, provider
+		}
+		optionsPage = append(options(Plain(basePage), baseProvider.Layout(), &base), optionsPage...)
+	}
+
+	return optionsPage, provider, nil
+}
+
+// DefaultRegistry is the registry used by Get and GetOptions. It asks bash
+// help, then man, then the markdown docs in ~/.bashly/docs. The --help output
+// of commands is only asked for once EnableUsage is called, as getting it
+// runs the programs named in the script.
+var DefaultRegistry = &Registry{}
+
+func init() {
+	DefaultRegistry.Register(Help{}, 10)
+	DefaultRegistry.Register(Man{}, 20)
+	if dir, err := markdownDir(); err == nil {
+		DefaultRegistry.Register(Markdown{Dir: dir}, 30)
+	}
+}
+
+// EnableUsage makes the default registry ask for the --help output of
+// commands after its other providers.
+func EnableUsage() {
+	DefaultRegistry.Register(Usage{}, 40)
+}
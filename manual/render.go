@@ -0,0 +1,229 @@
+package manual
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Style holds the looks of the parts of a page, each as a list of
+// attributes separated by commas, such as "bold,yellow". The attributes are
+// bold, underline, reverse and the colours black, red, green, yellow, blue,
+// magenta, cyan and white. A part that is "" gets its default look and a
+// part that is "none" is shown as plain text.
+type Style struct {
+	Heading  string `json:"heading"`  // section headings
+	Flag     string `json:"flag"`     // bold text, such as option flags
+	Argument string `json:"argument"` // underlined text, such as option arguments
+}
+
+// DefaultStyle is the style used for the parts of a style that are "".
+var DefaultStyle = Style{Heading: "bold,yellow", Flag: "bold,green", Argument: "underline,cyan"}
+
+// sgrCodes are the select graphic rendition codes of the style attributes,
+// as understood by gocui.
+var sgrCodes = map[string]int{
+	"bold": 1, "underline": 4, "reverse": 7,
+	"black": 30, "red": 31, "green": 32, "yellow": 33, "blue": 34, "magenta": 35, "cyan": 36, "white": 37,
+}
+
+// Validate returns an error if a part of the style has an unknown attribute.
+func (s Style) Validate() error {
+	for _, part := range []string{s.Heading, s.Flag, s.Argument} {
+		if _, err := sgr(part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sgr returns the escape sequence that turns on the attributes of a part of a
+// style, after turning off any others. Colours come before bold, underline
+// and reverse, as gocui drops those when it sets a colour.
+func sgr(part string) (string, error) {
+	colours, attrs := []string{"0"}, []string{}
+	for _, attr := range strings.Split(part, ",") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" || attr == "none" {
+			continue
+		}
+		code, ok := sgrCodes[attr]
+		if !ok {
+			return "", errors.New("unknown style attribute " + attr)
+		}
+		if code >= 30 {
+			colours = append(colours, strconv.Itoa(code))
+		} else {
+			attrs = append(attrs, strconv.Itoa(code))
+		}
+	}
+
+	return "\x1b[" + strings.Join(append(colours, attrs...), ";") + "m", nil
+}
+
+// format is the formatting of a rune in a page.
+type format int
+
+const (
+	plain format = iota
+	bold
+	underline
+	heading
+)
+
+// cell is a rune of a page along with its formatting.
+type cell struct {
+	r      rune
+	format format
+}
+
+// headingIndent is the indentation below which a line in bold is a heading,
+// as the text of man pages is indented by 7.
+const headingIndent = 7
+
+// cells splits a page into its lines of runes, taking the formatting from
+// overstrikes, like "a\ba" for bold and "_\ba" for underline, and from select
+// graphic rendition sequences. Other escape sequences are dropped.
+func cells(page Page) [][]cell {
+	lines := [][]cell{{}}
+	current := plain
+
+	for i := 0; i < len(page); {
+		r, size := utf8.DecodeRune(page[i:])
+		i += size
+		line := &lines[len(lines)-1]
+
+		switch {
+		case r == '\n':
+			lines = append(lines, []cell{})
+		case r == '\b' && len(*line) > 0 && i < len(page):
+			next, size := utf8.DecodeRune(page[i:])
+			i += size
+			prev := &(*line)[len(*line)-1]
+			switch {
+			case prev.r == next:
+				prev.format = bold
+			case prev.r == '_':
+				prev.r, prev.format = next, underline
+			case next == '_':
+				prev.format = underline
+			default:
+				prev.r, prev.format = next, bold
+			}
+		case r == 0x1b && i < len(page) && page[i] == '[':
+			j := i + 1
+			for j < len(page) && (page[j] >= '0' && page[j] <= '9' || page[j] == ';') {
+				j++
+			}
+			if j < len(page) && page[j] == 'm' {
+				current = sgrFormat(current, string(page[i+1:j]))
+			}
+			i = j + 1
+		case r == 0x1b && i < len(page) && page[i] == ']':
+			// Operating system commands, such as links, end with BEL or ESC \
+			j := i + 1
+			for j < len(page) && page[j] != 0x07 && page[j] != 0x1b {
+				j++
+			}
+			if j < len(page) && page[j] == 0x1b {
+				j++
+			}
+			i = j + 1
+		case r == '\b' || r == 0x1b:
+		default:
+			*line = append(*line, cell{r, current})
+		}
+	}
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		indent := 0
+		for indent < len(line) && line[indent].r == ' ' {
+			indent++
+		}
+		if indent >= headingIndent || indent == len(line) || line[indent].format != bold {
+			continue
+		}
+		for k := range line {
+			if line[k].format == bold {
+				line[k].format = heading
+			}
+		}
+	}
+
+	return lines
+}
+
+// sgrFormat returns the formatting after a select graphic rendition sequence
+// with the given parameters.
+func sgrFormat(current format, params string) format {
+	for _, param := range strings.Split(params, ";") {
+		switch param {
+		case "", "0", "22", "23", "24":
+			current = plain
+		case "1":
+			current = bold
+		case "3", "4":
+			current = underline
+		}
+	}
+
+	return current
+}
+
+// Plain returns the text of a page without its formatting.
+func Plain(page Page) Page {
+	var text []byte
+	for _, line := range cells(page) {
+		for _, c := range line {
+			text = append(text, string(c.r)...)
+		}
+		text = append(text, '\n')
+	}
+
+	return text
+}
+
+// Render returns the text of a page with its formatting turned into escape
+// sequences that show it in the given style. Removing the escape sequences
+// gives the text returned by Plain, so offsets in the text of a view are
+// offsets in the plain page.
+func Render(page Page, style Style) Page {
+	sequences := map[format]string{plain: "\x1b[0m"}
+	for f, part := range map[format][2]string{
+		heading:   {style.Heading, DefaultStyle.Heading},
+		bold:      {style.Flag, DefaultStyle.Flag},
+		underline: {style.Argument, DefaultStyle.Argument},
+	} {
+		if part[0] == "" {
+			part[0] = part[1]
+		}
+		sequence, err := sgr(part[0])
+		if err != nil {
+			sequence, _ = sgr(part[1])
+		}
+		sequences[f] = sequence
+	}
+
+	var text []byte
+	for _, line := range cells(page) {
+		current := plain
+		for _, c := range line {
+			if c.format != current {
+				text = append(text, sequences[c.format]...)
+				current = c.format
+			}
+			text = append(text, string(c.r)...)
+		}
+		if current != plain {
+			text = append(text, sequences[plain]...)
+		}
+		text = append(text, '\n')
+	}
+
+	return text
+}
@@ -0,0 +1,107 @@
+package manual
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// overstrike formats text as bold or underlined the way grotty does without
+// escape sequences.
+func overstrike(text string, underlined bool) string {
+	var out strings.Builder
+	for _, r := range text {
+		if underlined {
+			out.WriteString("_\b" + string(r))
+		} else {
+			out.WriteString(string(r) + "\b" + string(r))
+		}
+	}
+
+	return out.String()
+}
+
+func TestRender(t *testing.T) {
+	style := Style{Heading: "bold,yellow", Flag: "green", Argument: "none"}
+
+	tests := []struct {
+		page     string
+		plain    string
+		rendered string
+	}{
+		{
+			overstrike("NAME", false) + "\n       ls - list\n",
+			"NAME\n       ls - list\n",
+			"\x1b[0;33;1mNAME\x1b[0m\n       ls - list\n",
+		},
+		{
+			"       " + overstrike("-w", false) + ", " + overstrike("--width", false) + "=" + overstrike("COLS", true) + "\n",
+			"       -w, --width=COLS\n",
+			"       \x1b[0;32m-w\x1b[0m, \x1b[0;32m--width\x1b[0m=\x1b[0mCOLS\x1b[0m\n",
+		},
+		{
+			"\x1b[1mSYNOPSIS\x1b[0m\n       \x1b[1mls\x1b[22m [\x1b[4mOPTION\x1b[24m]...\n",
+			"SYNOPSIS\n       ls [OPTION]...\n",
+			"\x1b[0;33;1mSYNOPSIS\x1b[0m\n       \x1b[0;32mls\x1b[0m [\x1b[0mOPTION\x1b[0m]...\n",
+		},
+		{
+			"   " + overstrike("Größe", false) + "\n       see \x1b]8;;man:ls(1)\x1b\\ls\x1b]8;;\x1b\\(1)\n",
+			"   Größe\n       see ls(1)\n",
+			"   \x1b[0;33;1mGröße\x1b[0m\n       see ls(1)\n",
+		},
+	}
+
+	sgr := regexp.MustCompile("\x1b\\[[0-9;]*m")
+	for _, test := range tests {
+		plain, rendered := Plain(Page(test.page)), Render(Page(test.page), style)
+		if string(plain) != test.plain {
+			t.Errorf("%q: expected plain %q, got %q", test.page, test.plain, plain)
+		}
+		if string(rendered) != test.rendered {
+			t.Errorf("%q: expected rendered %q, got %q", test.page, test.rendered, rendered)
+		}
+		// The text of a view showing the rendered page, which is searched, must
+		// be the plain page
+		if text := sgr.ReplaceAll(rendered, nil); string(text) != string(plain) {
+			t.Errorf("%q: expected text %q, got %q", test.page, plain, text)
+		}
+	}
+}
+
+func TestSgr(t *testing.T) {
+	tests := []struct {
+		part string
+		sgr  string
+	}{
+		{"bold,yellow", "\x1b[0;33;1m"},
+		{"underline, cyan", "\x1b[0;36;4m"},
+		{"reverse,bold,red", "\x1b[0;31;7;1m"},
+		{"none", "\x1b[0m"},
+	}
+
+	for _, test := range tests {
+		if sgr, err := sgr(test.part); err != nil || sgr != test.sgr {
+			t.Errorf("%s: expected %q, got %q (%v)", test.part, test.sgr, sgr, err)
+		}
+	}
+}
+
+func TestStyleValidate(t *testing.T) {
+	if err := (Style{Heading: "bold, yellow", Flag: "none"}).Validate(); err != nil {
+		t.Error("Expected valid style, got", err)
+	}
+	if err := (Style{Argument: "italic"}).Validate(); err == nil {
+		t.Error("Expected an error for an unknown attribute")
+	}
+}
+
+func TestGetOptionsFormatted(t *testing.T) {
+	page := overstrike("OPTIONS", false) + "\n       " + overstrike("-l", false) + "     use a long listing format\n"
+
+	opts := options(Plain(Page(page)), ManLayout, &cmds.Command{Options: []cmds.Option{{Flag: "-l"}}})
+	if string(opts) != "       -l     use a long listing format\n" {
+		t.Errorf("Expected the plain description of -l, got %q", opts)
+	}
+}
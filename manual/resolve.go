@@ -0,0 +1,108 @@
+package manual
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// Type is the type of what runs for a command name, as told by type -t.
+type Type int
+
+// Types of what runs for a command name.
+const (
+	NotFound Type = iota
+	File
+	Alias
+	Function
+	Builtin
+	Keyword
+)
+
+// String returns the name of the type, as type -t prints it, or "" if the
+// command isn't found.
+func (t Type) String() string {
+	return [...]string{"", "file", "alias", "function", "builtin", "keyword"}[t]
+}
+
+// Resolution is what runs for a command.
+type Resolution struct {
+	Name  string
+	Type  Type
+	Path  string // file of a program
+	Line  int    // line a function is defined on in the script
+	Alias string // value of an alias
+	NoDir bool   // relative path that can't be found as the directory of the script isn't known
+}
+
+// String describes the resolution, such as "rsync → /usr/bin/rsync" or
+// "deploy (function, line 42)".
+func (r Resolution) String() string {
+	switch r.Type {
+	case File:
+		if r.Path == r.Name {
+			return r.Name
+		}
+		return r.Name + " → " + r.Path
+	case Alias:
+		return r.Name + " (alias for " + r.Alias + ")"
+	case Function:
+		return r.Name + " (function, line " + strconv.Itoa(r.Line) + ")"
+	case Builtin, Keyword:
+		return r.Name + " (" + r.Type.String() + ")"
+	}
+	if r.NoDir {
+		return r.Name + " (directory of the script not known)"
+	}
+
+	return r.Name + ": command not found"
+}
+
+// Resolve returns what runs for a command, looking through the aliases and
+// functions defined in the script, which can be nil, then the builtins and
+// PATH, in the order bash does. A command run by a wrapper other than time is
+// a program, as wrappers like sudo run programs. Relative paths, such as
+// ./deploy.sh, are found from dir, the directory of the script, which is ""
+// if it isn't known.
+func Resolve(command *cmds.Command, defs *cmds.Definitions, dir string) Resolution {
+	r := Resolution{Name: command.Name}
+	wrapped := runByWrapper(command)
+	if defs == nil {
+		defs = &cmds.Definitions{}
+	}
+
+	alias, isAlias := defs.Aliases[command.Name]
+	line, isFunction := defs.Functions[command.Name]
+	switch {
+	case command.Kind == cmds.Reserved:
+		r.Type = Keyword
+	case isAlias && !wrapped:
+		r.Type, r.Alias = Alias, alias
+	case isFunction && !wrapped:
+		r.Type, r.Line = Function, line
+	case isBuiltin(command):
+		r.Type = Builtin
+	case strings.ContainsRune(command.Name, '/'):
+		path := command.Name
+		if !filepath.IsAbs(path) {
+			if dir == "" {
+				r.NoDir = true
+				break
+			}
+			path = filepath.Join(dir, path)
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			r.Type, r.Path = File, path
+		}
+	case validName.MatchString(command.Name):
+		if path, err := exec.LookPath(command.Name); err == nil {
+			r.Type, r.Path = File, path
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,61 @@
+package manual
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+func TestResolve(t *testing.T) {
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "rsync"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "deploy.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	script := "alias ll='ls -l'\ndeploy() {\n\trsync -a . \"$1\"\n}\n"
+	defs, err := cmds.FindDefinitions(script)
+	if err != nil {
+		t.Fatal("Expected definitions, got", err)
+	}
+
+	tests := []struct {
+		line       string
+		dir        string
+		resolution string
+	}{
+		{"rsync -a . x\n", dir, "rsync → " + filepath.Join(bin, "rsync")},
+		{"deploy prod\n", dir, "deploy (function, line 2)"},
+		{"time deploy prod\n", dir, "deploy (function, line 2)"},
+		{"sudo deploy prod\n", dir, "deploy: command not found"},
+		{"ll /tmp\n", dir, "ll (alias for ls -l)"},
+		{"cd /tmp\n", dir, "cd (builtin)"},
+		{"if true; then :; fi\n", dir, "if (keyword)"},
+		{"missing --flag\n", dir, "missing: command not found"},
+		{"./missing.sh\n", dir, "./missing.sh: command not found"},
+		{"./deploy.sh prod\n", dir, "./deploy.sh → " + filepath.Join(dir, "deploy.sh")},
+		{"bin/../deploy.sh\n", dir, "bin/../deploy.sh → " + filepath.Join(dir, "deploy.sh")},
+		{"./deploy.sh prod\n", "", "./deploy.sh (directory of the script not known)"},
+	}
+
+	for _, test := range tests {
+		cmd, err := cmds.Find(test.line, 1)
+		if err != nil {
+			t.Fatal("Expected command, got", err)
+		}
+		if resolution := Resolve(cmd.Inner(), defs, test.dir).String(); resolution != test.resolution {
+			t.Errorf("%q: expected %q, got %q", test.line, test.resolution, resolution)
+		}
+	}
+
+	if resolution := Resolve(&cmds.Command{Name: os.Args[0]}, nil, ""); resolution.Type != File {
+		t.Error("Expected a path to a program to be a file, got", resolution)
+	}
+}
@@ -0,0 +1,627 @@
+package manual
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// roff renders the source of a manual page written with the man(7) or
+// mdoc(7) macros to text filled to a width. Bold and underlined text is
+// written as overstrikes, the way grotty writes it, so a rendered page reads
+// like one formatted by man.
+type roff struct {
+	width int
+	out   []byte
+	blank bool // whether the last line written is blank, or none is written yet
+
+	line  []cell // the line being filled, nil if it isn't started
+	col   int    // width of the line being filled
+	space bool   // whether a space goes before the next word
+	join  bool   // whether the next input line continues the last word, after \c
+	fill  bool
+
+	indent   int    // indentation of lines
+	first    int    // indentation of the next line instead, if not -1
+	base     int    // indentation of paragraphs
+	saved    []int  // bases saved by .RS
+	tagWidth int    // prevailing indentation of tagged paragraphs
+	after    func() // called once the next input line has written text
+
+	font      format
+	lineFont  format // font of the next input line, after .B without arguments
+	fontLine  bool   // whether lineFont is set
+	strings   map[string]string
+	macros    map[string][]string
+	cond      bool // result of the last .ie, for .el
+	depth     int  // depth of macro calls and string expansions
+	url       string
+	mdocState mdocState
+}
+
+// nbsp is a space that doesn't separate words.
+const nbsp = ' '
+
+// renderRoff renders the source of a manual page to the given width.
+func renderRoff(src []byte, width int) Page {
+	if width < 20 {
+		width = 80
+	}
+	r := &roff{
+		width: width, blank: true, fill: true,
+		indent: 7, first: -1, base: 7, tagWidth: 7,
+		strings: map[string]string{}, macros: map[string][]string{},
+		mdocState: mdocState{fo: -1},
+	}
+	r.run(&input{lines: sourceLines(src)})
+	r.flush()
+
+	return Page(r.out)
+}
+
+// input is the lines of roff source being read.
+type input struct {
+	lines []string
+	i     int
+}
+
+// next returns the next line.
+func (in *input) next() (string, bool) {
+	if in.i >= len(in.lines) {
+		return "", false
+	}
+	in.i++
+
+	return in.lines[in.i-1], true
+}
+
+// sourceLines splits the source of a page into its lines, joining those that
+// end with a backslash to the next. Bytes that aren't UTF-8 are taken to be
+// Latin-1, which older pages are written in.
+func sourceLines(src []byte) []string {
+	var text strings.Builder
+	for len(src) > 0 {
+		r, size := utf8.DecodeRune(src)
+		if r == utf8.RuneError && size == 1 {
+			r = rune(src[0])
+		}
+		if r != '\r' {
+			text.WriteRune(r)
+		}
+		src = src[size:]
+	}
+
+	var lines []string
+	continued := ""
+	for _, line := range strings.Split(strings.TrimSuffix(text.String(), "\n"), "\n") {
+		line = continued + line
+		trailing := len(line) - len(strings.TrimRight(line, `\`))
+		if trailing%2 == 1 {
+			continued = line[:len(line)-1]
+			continue
+		}
+		continued = ""
+		lines = append(lines, line)
+	}
+	if continued != "" {
+		lines = append(lines, continued)
+	}
+
+	return lines
+}
+
+// run renders lines of input.
+func (r *roff) run(in *input) {
+	for {
+		line, ok := in.next()
+		if !ok {
+			return
+		}
+		r.process(line, in)
+	}
+}
+
+// process renders a line of input.
+func (r *roff) process(line string, in *input) {
+	if line != "" && (line[0] == '.' || line[0] == '\'') {
+		r.request(line[1:], in)
+	} else {
+		r.text(line)
+	}
+
+	if r.after != nil && r.line != nil {
+		after := r.after
+		r.after = nil
+		after()
+	}
+	// Without filling, each line of input is a line of output
+	if !r.fill && r.line != nil && !r.join && r.after == nil {
+		r.flush()
+	}
+}
+
+// text renders a line of text.
+func (r *roff) text(line string) {
+	if line == "" {
+		if r.fill {
+			r.blankLine()
+		} else {
+			r.flush()
+			r.writeLine(nil)
+		}
+		return
+	}
+
+	font := r.font
+	if r.fontLine {
+		font = r.lineFont
+	}
+	cells, end := r.parse(line, font)
+	if r.fontLine {
+		r.fontLine = false
+	} else {
+		r.font = end
+	}
+
+	if !r.fill {
+		r.raw(cells)
+		return
+	}
+	// A line starting with spaces starts an output line indented by them
+	if line[0] == ' ' || line[0] == '\t' {
+		r.flush()
+		r.first = r.indent + len(line) - len(strings.TrimLeft(line, " \t"))
+	}
+	r.words(cells)
+	if r.join {
+		r.space, r.join = false, false
+	}
+}
+
+// words fills the words of text into lines.
+func (r *roff) words(text []cell) {
+	start := -1
+	for i, c := range text {
+		if c.r == ' ' || c.r == '\t' {
+			if start >= 0 {
+				r.word(text[start:i])
+				start = -1
+			}
+			r.space = true
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		r.word(text[start:])
+	}
+}
+
+// word adds a word to the line being filled, starting a new line if the word
+// doesn't fit.
+func (r *roff) word(word []cell) {
+	if len(word) == 0 {
+		return
+	}
+	if r.line != nil && r.space {
+		if r.fill && r.col+1+len(word) > r.width {
+			r.flush()
+		} else {
+			r.line = append(r.line, cell{' ', plain})
+			r.col++
+		}
+	}
+	if r.line == nil {
+		r.start()
+	}
+
+	r.line = append(r.line, word...)
+	r.col += len(word)
+	r.space = true
+}
+
+// raw adds text to the line without filling it, expanding tabs.
+func (r *roff) raw(text []cell) {
+	if r.line == nil {
+		r.start()
+	}
+	start := r.indent
+	for _, c := range text {
+		if c.r == '\t' {
+			r.pad(r.col + 8 - (r.col-start)%8)
+			continue
+		}
+		r.line = append(r.line, c)
+		r.col++
+	}
+
+	if r.join {
+		r.join = false
+	} else {
+		r.flush()
+	}
+}
+
+// start starts a line at its indentation.
+func (r *roff) start() {
+	indent := r.indent
+	if r.first >= 0 {
+		indent = r.first
+		r.first = -1
+	}
+	r.line, r.col = []cell{}, 0
+	r.pad(indent)
+}
+
+// pad adds spaces to the line up to the given column.
+func (r *roff) pad(col int) {
+	for r.col < col {
+		r.line = append(r.line, cell{' ', plain})
+		r.col++
+	}
+}
+
+// endTag ends the tag of a tagged paragraph, such as an option, whose body is
+// indented to the given column. The body starts on the line of the tag if
+// the tag is short enough.
+func (r *roff) endTag(body int) {
+	r.indent = body
+	if r.line != nil && r.col < body {
+		r.pad(body)
+		r.space = false
+	} else {
+		r.flush()
+	}
+}
+
+// flush writes the line being filled.
+func (r *roff) flush() {
+	if r.line == nil {
+		return
+	}
+	line := r.line
+	for len(line) > 0 && line[len(line)-1].r == ' ' {
+		line = line[:len(line)-1]
+	}
+	r.writeLine(line)
+	r.line, r.col = nil, 0
+}
+
+// blankLine ends the line being filled and writes a blank line, unless the
+// last line written is blank.
+func (r *roff) blankLine() {
+	r.flush()
+	if !r.blank {
+		r.writeLine(nil)
+	}
+}
+
+// writeLine writes a line of output, with overstrikes for its formatting.
+func (r *roff) writeLine(line []cell) {
+	for _, c := range line {
+		ch := c.r
+		if ch == nbsp {
+			ch = ' '
+		}
+		switch {
+		case ch == ' ' || c.format == plain:
+			r.out = append(r.out, string(ch)...)
+		case c.format == underline:
+			r.out = append(r.out, "_\b"+string(ch)...)
+		default:
+			r.out = append(r.out, string(ch)+"\b"+string(ch)...)
+		}
+	}
+	r.out = append(r.out, '\n')
+	r.blank = len(line) == 0
+}
+
+// parse returns the runes of text after interpreting its escapes, starting
+// in the given font, along with the font at its end.
+func (r *roff) parse(text string, font format) ([]cell, format) {
+	var out []cell
+	prev := font
+	add := func(s string) {
+		for _, ch := range s {
+			out = append(out, cell{ch, font})
+		}
+	}
+
+	for i := 0; i < len(text); {
+		ch, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		if ch != '\\' {
+			if ch == ' ' || ch == '\t' {
+				out = append(out, cell{ch, plain})
+			} else {
+				out = append(out, cell{ch, font})
+			}
+			continue
+		}
+		if i >= len(text) {
+			break
+		}
+
+		escape, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		var name string
+		switch escape {
+		case 'f':
+			name, i = escapeName(text, i)
+			switch next := fontFormat(name, prev); {
+			case name == "P" || name == "":
+				font, prev = prev, font
+			default:
+				font, prev = next, font
+			}
+		case '(', '[':
+			name, i = escapeName(text, i-1)
+			add(specialChar(name))
+		case 'C':
+			name, i = quoted(text, i)
+			add(specialChar(name))
+		case '*':
+			name, i = escapeName(text, i)
+			if r.depth < 20 {
+				r.depth++
+				cells, end := r.parse(r.str(name), font)
+				r.depth--
+				out, font = append(out, cells...), end
+			}
+		case 'e', 'E', '\\':
+			add(`\`)
+		case '-':
+			add("-")
+		case '.':
+			add(".")
+		case '\'':
+			add("'")
+		case '`':
+			add("`")
+		case '_':
+			add("_")
+		case ' ', '~', '0':
+			add(string(nbsp))
+		case 't':
+			out = append(out, cell{'\t', plain})
+		case 'c':
+			r.join = true
+		case '"', '#':
+			i = len(text)
+		case 's':
+			i = skipSize(text, i)
+		case 'n':
+			if i < len(text) && (text[i] == '+' || text[i] == '-') {
+				i++
+			}
+			_, i = escapeName(text, i)
+		case 'g', 'm', 'M', 'F', 'V', 'Y', 'k', '$', 'O':
+			_, i = escapeName(text, i)
+		case 'h', 'v', 'w', 'o', 'l', 'L', 'D', 'X', 'Z', 'b', 'x', 'R', 'S', 'H', 'N', 'A', 'B', 'T', 'U':
+			_, i = quoted(text, i)
+		case '&', ')', '|', '^', ',', '/', ':', '%', 'a', '{', '}', 'p', 'r', 'u', 'd', 'z':
+		default:
+			add(string(escape))
+		}
+	}
+
+	return out, font
+}
+
+// escapeName returns the name that an escape takes at the given index, such
+// as B in \fB, em in \(em or name in \*[name], and the index after it.
+func escapeName(text string, i int) (string, int) {
+	if i >= len(text) {
+		return "", i
+	}
+	switch text[i] {
+	case '(':
+		end := i + 3
+		if end > len(text) {
+			end = len(text)
+		}
+		return text[i+1 : end], end
+	case '[':
+		end := strings.IndexByte(text[i:], ']')
+		if end < 0 {
+			return text[i+1:], len(text)
+		}
+		return text[i+1 : i+end], i + end + 1
+	}
+	_, size := utf8.DecodeRuneInString(text[i:])
+
+	return text[i : i+size], i + size
+}
+
+// quoted returns the argument between delimiters that an escape takes at the
+// given index, such as 3n in \h'3n', and the index after it.
+func quoted(text string, i int) (string, int) {
+	if i >= len(text) {
+		return "", i
+	}
+	end := strings.IndexByte(text[i+1:], text[i])
+	if end < 0 {
+		return text[i+1:], len(text)
+	}
+
+	return text[i+1 : i+1+end], i + end + 2
+}
+
+// skipSize returns the index after the argument of a \s escape.
+func skipSize(text string, i int) int {
+	if i < len(text) && (text[i] == '+' || text[i] == '-') {
+		i++
+	}
+	if i >= len(text) {
+		return i
+	}
+	switch c := text[i]; {
+	case c == '(' || c == '[':
+		_, i = escapeName(text, i)
+	case c == '\'':
+		_, i = quoted(text, i)
+	case c >= '1' && c <= '3' && i+1 < len(text) && text[i+1] >= '0' && text[i+1] <= '9':
+		i += 2
+	default:
+		i++
+	}
+
+	return i
+}
+
+// fontFormat returns the formatting of a font, or the given one for the
+// previous font.
+func fontFormat(name string, prev format) format {
+	switch name {
+	case "B", "3", "4", "BI", "CB", "BD":
+		return bold
+	case "I", "2", "CI", "IT":
+		return underline
+	case "P", "":
+		return prev
+	}
+
+	return plain
+}
+
+// str returns the value of a string, defined by the page or predefined.
+func (r *roff) str(name string) string {
+	if value, ok := r.strings[name]; ok {
+		return value
+	}
+
+	return predefinedStrings[name]
+}
+
+// predefinedStrings are the strings that the macro packages define.
+var predefinedStrings = map[string]string{
+	"R": "®", "Tm": "™", "lq": "“", "rq": "”", "Lq": "“", "Rq": "”",
+	"aq": "'", "Aq": "'", "q": `"`, "Ba": "|", "Am": "&", "Pi": "π",
+	"Ne": "≠", "Le": "≤", "Ge": "≥", "Lt": "<", "Gt": ">", "Pm": "±",
+	"If": "∞", "Na": "NaN",
+}
+
+// specialChars are the characters named by escapes like \(em.
+var specialChars = map[string]string{
+	"em": "—", "en": "–", "hy": "-", "bu": "•", "lq": "“", "rq": "”",
+	"oq": "‘", "cq": "’", "aq": "'", "dq": `"`, "Fo": "«", "Fc": "»",
+	"fo": "‹", "fc": "›", "bq": "„", "Bq": "„", "co": "©", "rg": "®",
+	"tm": "™", "de": "°", "mu": "×", "di": "÷", "+-": "±", "<=": "≤",
+	">=": "≥", "!=": "≠", "==": "≡", "~=": "≅", "ap": "~", "->": "→",
+	"<-": "←", "<>": "↔", "ua": "↑", "da": "↓", "rA": "⇒", "lA": "⇐",
+	"hA": "⇔", "sc": "§", "ps": "¶", "ti": "~", "ha": "^", "rs": `\`,
+	"sl": "/", "ba": "|", "bv": "|", "or": "|", "ga": "`", "aa": "´",
+	"ul": "_", "ru": "_", "dg": "†", "dd": "‡", "fm": "′", "sq": "□",
+	"pl": "+", "mi": "−", "eq": "=", "lB": "[", "rB": "]", "lC": "{",
+	"rC": "}", "la": "⟨", "ra": "⟩", "at": "@", "sh": "#", "Do": "$",
+	"Eu": "€", "ct": "¢", "Po": "£", "Ye": "¥", "ss": "ß", "no": "¬",
+	"tno": "¬", "12": "½", "14": "¼", "34": "¾", "S1": "¹", "S2": "²",
+	"S3": "³", "mc": "µ", "*m": "µ", "*a": "α", "*b": "β", "*p": "π",
+	"ci": "○", "es": "∅", "if": "∞", "mo": "∈", "nm": "∉", "sr": "√",
+	"pc": "·", "md": "⋅", "OK": "✓", "'e": "é", "`e": "è", "'a": "á",
+	"`a": "à", ":a": "ä", ":o": "ö", ":u": "ü", ":A": "Ä", ":O": "Ö",
+	":U": "Ü", "oa": "å", "~n": "ñ", ",c": "ç", "'E": "É",
+}
+
+// specialChar returns the character of a special character name, including
+// Unicode names like u00E9.
+func specialChar(name string) string {
+	if s, ok := specialChars[name]; ok {
+		return s
+	}
+	if len(name) > 1 && name[0] == 'u' {
+		code := name[1:]
+		if i := strings.IndexByte(code, '_'); i >= 0 {
+			code = code[:i]
+		}
+		if n, err := strconv.ParseUint(code, 16, 32); err == nil {
+			return string(rune(n))
+		}
+	}
+	if utf8.RuneCountInString(name) == 1 {
+		return name
+	}
+
+	return ""
+}
+
+// splitArgs splits the arguments of a request or macro, which are separated
+// by spaces unless they are in quotes.
+func splitArgs(text string) []string {
+	var args []string
+	for i := 0; i < len(text); {
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+		if i >= len(text) {
+			break
+		}
+
+		var arg strings.Builder
+		quote := text[i] == '"'
+		if quote {
+			i++
+		}
+		for i < len(text) {
+			c := text[i]
+			switch {
+			case quote && c == '"' && i+1 < len(text) && text[i+1] == '"':
+				arg.WriteByte('"')
+				i += 2
+				continue
+			case quote && c == '"':
+				i++
+			case !quote && (c == ' ' || c == '\t'):
+			case c == '\\' && i+1 < len(text) && text[i+1] == '"':
+				// A comment ends the arguments
+				if quote || arg.Len() > 0 {
+					args = append(args, arg.String())
+				}
+				return args
+			case c == '\\' && i+1 < len(text):
+				arg.WriteString(text[i : i+2])
+				i += 2
+				continue
+			default:
+				arg.WriteByte(c)
+				i++
+				continue
+			}
+			break
+		}
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// number returns the value of a length in characters, such as 4n or 0.5i, or
+// def if it isn't one.
+func number(arg string, def int) int {
+	scale := 1.0
+	if arg != "" {
+		switch arg[len(arg)-1] {
+		case 'i':
+			scale = 10
+		case 'c':
+			scale = 4
+		case 'p':
+			scale = 10.0 / 72
+		case 'P':
+			scale = 10.0 / 6
+		case 'u':
+			scale = 1.0 / 24
+		}
+		arg = strings.TrimRight(arg, "icpPumnvM")
+	}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return def
+	}
+	value *= scale
+	if value < 0 {
+		return int(value - 0.5)
+	}
+
+	return int(value + 0.5)
+}
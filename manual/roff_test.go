@@ -0,0 +1,127 @@
+package manual
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+func TestRenderRoff(t *testing.T) {
+	tests := []struct {
+		page  string
+		width int
+		lines []string
+	}{
+		{"ls.1", 60, []string{
+			"LS(1)                  User Commands                   LS(1)",
+			"NAME",
+			"       ls - list directory contents",
+			"       ls [OPTION]... [FILE]...",
+			"       List information about the FILEs (the current",
+			"       none of -cftuvSUX nor --sort is specified.",
+			"       -a, --all",
+			"              do not ignore entries starting with .",
+			"       -w, --width=COLS",
+			"   Exit status:",
+			"       0      if OK,",
+			"       2   if serious trouble.",
+			"           Quoted: 'n'",
+			"              ls -l   /tmp",
+			"       dir(1), full documentation",
+			"       <https://www.gnu.org/software/coreutils/ls>.",
+		}},
+		{"ls.1", 100, []string{
+			"       List information about the FILEs (the current directory by default). Sort entries",
+			"              with -l, scale sizes by SIZE when printing them",
+		}},
+		{"cat.1", 60, []string{
+			"CAT(1)            General Commands Manual             CAT(1)",
+			"       cat — concatenate and print files",
+			"       cat [-belnstuv] [file ...]",
+			"       -b    Number the non-blank output lines, starting at",
+			"       --number=start",
+			"             Number lines from start.",
+			"       The cat utility exits 0 on success, and >0 if an",
+			"       head(1), tail(1)",
+			"       1003.2-1992 (“POSIX.2”) specification.",
+		}},
+	}
+
+	for _, test := range tests {
+		src, err := ioutil.ReadFile("testdata/man/man1/" + test.page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := string(Plain(renderRoff(src, test.width)))
+
+		lines := map[string]bool{}
+		for _, line := range strings.Split(page, "\n") {
+			if len([]rune(line)) > test.width {
+				t.Errorf("%s at %d: expected lines to fit, got %q", test.page, test.width, line)
+			}
+			lines[line] = true
+		}
+		for _, line := range test.lines {
+			if !lines[line] {
+				t.Errorf("%s at %d: expected line %q in\n%s", test.page, test.width, line, page)
+			}
+		}
+		if strings.HasSuffix(page, "\n\n") {
+			t.Errorf("%s at %d: expected no blank line at the end", test.page, test.width)
+		}
+	}
+}
+
+func TestRenderRoffFormatting(t *testing.T) {
+	src := ".SH OPTIONS\n.TP\n.B \\-l\nuse a \\fIlong\\fP listing format\n"
+	expected := overstrike("OPTIONS", false) + "\n\n       " + overstrike("-l", false) +
+		"     use a " + overstrike("long", true) + " listing format\n"
+	if page := string(renderRoff([]byte(src), 80)); page != expected {
+		t.Errorf("Expected %q, got %q", expected, page)
+	}
+
+	// The options of a rendered page are found like those of a page from man
+	opts := options(Plain(renderRoff([]byte(src), 80)), ManLayout, &cmds.Command{Options: []cmds.Option{{Flag: "-l"}}})
+	if string(opts) != "       -l     use a long listing format\n" {
+		t.Errorf("Expected the description of -l, got %q", opts)
+	}
+}
+
+func TestRenderMdocSpacing(t *testing.T) {
+	src := ".Dt SSH 1\n.Sh DESCRIPTION\nConnect to either\n.Sm off\n.Oo Ar user @ Oc Ar hostname\n.Sm on\n" +
+		"or a URI of the form\n.Sm off\n.No ssh:// Oo Ar user @ Oc Ar hostname\n.Sm on\n"
+	page := string(Plain(renderRoff([]byte(src), 80)))
+
+	expected := "       Connect to either [user@]hostname or a URI of the form ssh://[user@]hostname\n"
+	if !strings.Contains(page, expected) {
+		t.Errorf("Expected %q in %q", expected, page)
+	}
+}
+
+func TestManPath(t *testing.T) {
+	t.Setenv("MANPATH", "testdata/man")
+
+	tests := []struct {
+		name  string
+		title string
+	}{
+		{"ls", "LS(1)"},
+		{"cat", "CAT(1)"},
+		{"dir", "LS(1)"}, // an alias of ls by .so
+		{"missing", ""},
+	}
+
+	for _, test := range tests {
+		page, err := (Man{}).Get(context.Background(), &cmds.Command{Name: test.name}, 80)
+		title := ""
+		if err == nil {
+			title = strings.Fields(string(Plain(page)))[0]
+		}
+		if title != test.title {
+			t.Errorf("%s: expected page %q, got %q (%v)", test.name, test.title, title, err)
+		}
+	}
+}
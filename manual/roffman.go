@@ -0,0 +1,509 @@
+package manual
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// request renders a line of input that is a request or a macro call, without
+// its control character.
+func (r *roff) request(line string, in *input) {
+	line = strings.TrimLeft(line, " \t")
+	name, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, rest = line[:i], strings.TrimLeft(line[i+1:], " \t")
+	}
+	if name == "" || name[0] == '\\' {
+		return
+	}
+	args := splitArgs(rest)
+
+	switch name {
+	case "if", "ie", "el":
+		r.conditional(name, rest, in)
+	case "de", "de1", "am", "am1":
+		r.define(name, args, in)
+	case "ig":
+		end := ".."
+		if len(args) > 0 {
+			end = "." + args[0]
+		}
+		skipTo(in, end)
+	case "EQ":
+		skipTo(in, ".EN")
+	case "PS":
+		skipTo(in, ".PE")
+	case "ds", "ds1", "as", "as1":
+		if len(args) > 0 {
+			value := strings.TrimPrefix(strings.TrimLeft(strings.TrimPrefix(rest, args[0]), " \t"), `"`)
+			if strings.HasPrefix(name, "as") {
+				value = r.strings[args[0]] + value
+			}
+			r.strings[args[0]] = value
+		}
+	case "nop":
+		r.text(rest)
+	case "TS":
+		r.table(in)
+	default:
+		if r.man(name, args) || r.mdoc(name, args, rest) {
+			return
+		}
+		if body, ok := r.macros[name]; ok && r.depth < 20 {
+			r.depth++
+			lines := make([]string, len(body))
+			for i, line := range body {
+				lines[i] = expandArgs(line, args)
+			}
+			r.run(&input{lines: lines})
+			r.depth--
+		}
+	}
+}
+
+// skipTo skips the lines of input up to one that starts with end.
+func skipTo(in *input, end string) {
+	for {
+		line, ok := in.next()
+		if !ok || strings.HasPrefix(strings.TrimSpace(line), end) {
+			return
+		}
+	}
+}
+
+// define records the definition of a macro by .de or .am.
+func (r *roff) define(request string, args []string, in *input) {
+	if len(args) == 0 {
+		return
+	}
+	end := ".."
+	if len(args) > 1 {
+		end = "." + args[1]
+	}
+
+	var body []string
+	for {
+		line, ok := in.next()
+		if !ok || strings.TrimSpace(line) == end {
+			break
+		}
+		body = append(body, line)
+	}
+	if strings.HasPrefix(request, "am") {
+		body = append(r.macros[args[0]], body...)
+	}
+	r.macros[args[0]] = body
+}
+
+// expandArgs puts the arguments of a macro call into a line of its
+// definition, which is read in copy mode, where \\ is a backslash.
+func expandArgs(line string, args []string) string {
+	var out strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\\' {
+			i++
+		}
+		if line[i] == '\\' && i+2 < len(line) && line[i+1] == '$' {
+			switch c := line[i+2]; {
+			case c >= '1' && c <= '9':
+				if n := int(c - '1'); n < len(args) {
+					out.WriteString(args[n])
+				}
+				i += 2
+				continue
+			case c == '*' || c == '@':
+				out.WriteString(strings.Join(args, " "))
+				i += 2
+				continue
+			}
+		}
+		out.WriteByte(line[i])
+	}
+
+	return out.String()
+}
+
+// conditional renders the body of .if, .ie or .el if its condition holds,
+// skipping the lines of a block in \{ and \} otherwise.
+func (r *roff) conditional(request, rest string, in *input) {
+	var ok bool
+	body := rest
+	if request == "el" {
+		ok = !r.cond
+	} else {
+		ok, body = r.condition(rest)
+		if request == "ie" {
+			r.cond = ok
+		}
+	}
+	body = strings.TrimLeft(body, " \t")
+
+	if !ok {
+		depth := strings.Count(body, `\{`) - strings.Count(body, `\}`)
+		for depth > 0 {
+			line, more := in.next()
+			if !more {
+				break
+			}
+			depth += strings.Count(line, `\{`) - strings.Count(line, `\}`)
+		}
+		return
+	}
+
+	body = strings.TrimLeft(strings.TrimPrefix(body, `\{`), " \t")
+	if strings.TrimSpace(strings.Replace(body, `\}`, "", -1)) != "" {
+		r.process(body, in)
+	}
+}
+
+// condition evaluates the condition at the start of the argument of .if or
+// .ie, as a formatter for terminals that is groff, and returns the rest of it.
+func (r *roff) condition(text string) (bool, string) {
+	negate := strings.HasPrefix(text, "!")
+	text = strings.TrimPrefix(text, "!")
+	if text == "" {
+		return false, ""
+	}
+
+	var ok bool
+	var end int
+	switch c := text[0]; {
+	case c == 'n' || c == 'o':
+		ok, end = true, 1
+	case c == 't' || c == 'e':
+		ok, end = false, 1
+	case strings.IndexByte("drcFmSv", c) >= 0 && len(text) > 1 && text[1] == ' ':
+		args := strings.Fields(text[2:])
+		end = len(text)
+		if len(args) > 0 {
+			end = strings.Index(text, args[0]) + len(args[0])
+			_, isString := r.strings[args[0]]
+			_, isMacro := r.macros[args[0]]
+			ok = c == 'c' || c == 'd' && (isString || isMacro)
+		}
+	case c >= '0' && c <= '9' || c == '(' || c == '\\' || c == '-' || c == '+':
+		end = strings.IndexAny(text, " \t")
+		if end < 0 {
+			end = len(text)
+		}
+		if i := strings.Index(text[:end], `\{`); i >= 0 {
+			end = i
+		}
+		expr := text[:end]
+		// groff is the only register that is set
+		ok = strings.Contains(expr, ".g") && !strings.ContainsAny(expr, "<=") ||
+			expr[0] >= '1' && expr[0] <= '9'
+	default:
+		// Strings compared as 'a'b'
+		first := strings.IndexByte(text[1:], c)
+		second := -1
+		if first >= 0 {
+			second = strings.IndexByte(text[first+2:], c)
+		}
+		if second < 0 {
+			return false, ""
+		}
+		ok = text[1:first+1] == text[first+2:first+2+second]
+		end = first + second + 3
+	}
+
+	return ok != negate, text[end:]
+}
+
+// man renders a request or a macro of man(7), returning false if it isn't one.
+func (r *roff) man(name string, args []string) bool {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+
+	switch name {
+	case "TH":
+		r.header(r.plainText(arg(0))+"("+r.plainText(arg(1))+")", r.plainText(arg(4)))
+	case "SH", "SS":
+		r.heading(name == "SS", args)
+	case "PP", "LP", "P":
+		r.blankLine()
+		r.indent, r.tagWidth = r.base, 7
+	case "TP", "TQ":
+		if name == "TP" {
+			r.blankLine()
+			r.tagWidth = number(arg(0), r.tagWidth)
+		} else {
+			r.flush()
+		}
+		r.indent = r.base
+		r.after = func() { r.endTag(r.base + r.tagWidth) }
+	case "IP":
+		r.blankLine()
+		r.tagWidth = number(arg(1), r.tagWidth)
+		r.indent = r.base
+		cells, _ := r.parse(arg(0), r.font)
+		r.words(cells)
+		r.endTag(r.base + r.tagWidth)
+	case "HP":
+		r.blankLine()
+		r.tagWidth = number(arg(0), r.tagWidth)
+		r.indent, r.first = r.base+r.tagWidth, r.base
+	case "RS":
+		r.flush()
+		r.saved = append(r.saved, r.base)
+		r.base += number(arg(0), r.tagWidth)
+		r.indent, r.tagWidth = r.base, 7
+	case "RE":
+		r.flush()
+		if len(r.saved) > 0 {
+			r.base = r.saved[len(r.saved)-1]
+			r.saved = r.saved[:len(r.saved)-1]
+		}
+		r.indent, r.tagWidth = r.base, 7
+	case "B", "I", "SB", "SM":
+		font := map[string]format{"B": bold, "I": underline, "SB": bold, "SM": r.font}[name]
+		if len(args) == 0 {
+			r.lineFont, r.fontLine = font, true
+			break
+		}
+		cells, _ := r.parse(strings.Join(args, " "), font)
+		r.words(cells)
+	case "BI", "BR", "IB", "IR", "RB", "RI":
+		fonts := map[byte]format{'B': bold, 'I': underline, 'R': plain}
+		var cells []cell
+		for i, a := range args {
+			part, _ := r.parse(a, fonts[name[i%2]])
+			cells = append(cells, part...)
+		}
+		r.words(cells)
+	case "nf", "EX":
+		r.flush()
+		r.fill = false
+	case "fi", "EE":
+		r.flush()
+		r.fill = true
+	case "br":
+		r.flush()
+	case "sp":
+		if number(arg(0), 1) > 0 {
+			r.blankLine()
+		} else {
+			r.flush()
+		}
+	case "in":
+		r.flush()
+		r.indent = r.relative(arg(0), r.indent, r.base)
+	case "ti":
+		r.flush()
+		r.first = r.relative(arg(0), r.indent, r.indent)
+	case "ft":
+		r.font = fontFormat(arg(0), plain)
+	case "UR", "MT":
+		r.url = arg(0)
+	case "UE", "ME":
+		if r.url != "" {
+			cells, _ := r.parse("<"+r.url+">", plain)
+			r.word(cells)
+		}
+		r.space = false
+		cells, _ := r.parse(arg(0), plain)
+		r.word(cells)
+		r.url = ""
+	case "SY":
+		r.blankLine()
+		r.indent, r.first = r.base+utf8.RuneCountInString(r.plainText(arg(0)))+1, r.base
+		cells, _ := r.parse(arg(0), bold)
+		r.words(cells)
+	case "OP":
+		cells, _ := r.parse("[", plain)
+		option, _ := r.parse(arg(0), bold)
+		cells = append(cells, option...)
+		if len(args) > 1 {
+			argument, _ := r.parse(arg(1), underline)
+			cells = append(append(cells, cell{' ', plain}), argument...)
+		}
+		r.words(append(cells, cell{']', plain}))
+	case "YS":
+		r.flush()
+		r.indent = r.base
+	default:
+		return false
+	}
+
+	return true
+}
+
+// relative returns an indentation set by .in or .ti, which is relative to
+// the current one if it has a sign, or def without an argument.
+func (r *roff) relative(arg string, current, def int) int {
+	switch {
+	case arg == "":
+		return def
+	case arg[0] == '+' || arg[0] == '-':
+		return current + number(arg, 0)
+	}
+
+	return number(arg, current)
+}
+
+// plainText returns the text of roff source without its formatting.
+func (r *roff) plainText(text string) string {
+	cells, _ := r.parse(text, plain)
+	var out strings.Builder
+	for _, c := range cells {
+		if c.r == nbsp {
+			c.r = ' '
+		}
+		out.WriteRune(c.r)
+	}
+
+	return out.String()
+}
+
+// header writes the header line of a page, with the title on both sides and
+// the name of the manual in the middle.
+func (r *roff) header(title, manual string) {
+	line := title
+	gap := r.width - 2*utf8.RuneCountInString(title)
+	if center := gap - utf8.RuneCountInString(manual); manual != "" && center >= 2 {
+		line += strings.Repeat(" ", center/2) + manual + strings.Repeat(" ", center-center/2) + title
+	} else if gap >= 2 {
+		line += strings.Repeat(" ", gap) + title
+	}
+
+	cells, _ := r.parse(strings.Replace(line, `\`, `\e`, -1), plain)
+	r.flush()
+	r.writeLine(cells)
+	r.blankLine()
+}
+
+// heading writes the heading of a section, or of a subsection if sub is
+// true. Without arguments, the heading is the next line of input.
+func (r *roff) heading(sub bool, args []string) {
+	r.blankLine()
+	r.saved, r.base, r.tagWidth, r.after, r.fill = nil, 7, 7, nil, true
+	r.indent, r.first = 0, -1
+	if sub {
+		r.indent = 3
+	}
+	r.mdocState.section = r.plainText(strings.Join(args, " "))
+
+	if len(args) == 0 {
+		r.lineFont, r.fontLine = bold, true
+		r.after = func() {
+			r.flush()
+			r.indent = r.base
+		}
+		return
+	}
+	cells, _ := r.parse(strings.Join(args, " "), bold)
+	r.words(cells)
+	r.flush()
+	r.indent = r.base
+}
+
+// tabOption matches the tab option of a table, such as tab(:).
+var tabOption = regexp.MustCompile(`tab *\((.)\)`)
+
+// table renders a table of tbl(1) up to .TE, with its columns aligned and the
+// text of its last column filled.
+func (r *roff) table(in *input) {
+	r.flush()
+	var lines []string
+	for {
+		line, ok := in.next()
+		if !ok || strings.HasPrefix(line, ".TE") {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	// Options end with a semicolon and the formats of the rows with a period
+	tab := "\t"
+	i := 0
+	if i < len(lines) && strings.HasSuffix(strings.TrimSpace(lines[i]), ";") {
+		if match := tabOption.FindStringSubmatch(lines[i]); match != nil {
+			tab = match[1]
+		}
+		i++
+	}
+	skipFormats := func() {
+		for i < len(lines) {
+			i++
+			if strings.HasSuffix(strings.TrimSpace(lines[i-1]), ".") {
+				return
+			}
+		}
+	}
+	skipFormats()
+
+	var rows [][][]cell
+	var widths []int
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case line == ".T&":
+			i++
+			skipFormats()
+			i--
+			continue
+		case strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") || strings.Trim(line, "_=") == "":
+			continue
+		}
+
+		var row [][]cell
+		parts := strings.Split(line, tab)
+		for len(parts) > 0 {
+			field := parts[0]
+			parts = parts[1:]
+			// Text blocks span lines from T{ to T}
+			if field == "T{" {
+				var block []string
+				for i+1 < len(lines) {
+					i++
+					if strings.HasPrefix(lines[i], "T}") {
+						parts = append(strings.Split(strings.TrimPrefix(lines[i], "T}"), tab)[1:], parts...)
+						break
+					}
+					if !strings.HasPrefix(lines[i], ".") {
+						block = append(block, lines[i])
+					}
+				}
+				field = strings.Join(block, " ")
+			}
+			cells, _ := r.parse(field, plain)
+			row = append(row, cells)
+			if len(widths) < len(row) {
+				widths = append(widths, 0)
+			}
+			if len(cells) > widths[len(row)-1] {
+				widths[len(row)-1] = len(cells)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	indent, fill := r.indent, r.fill
+	for _, row := range rows {
+		r.indent = indent
+		col := indent
+		for k, cells := range row {
+			if k == len(row)-1 {
+				r.indent, r.fill = col, true
+				if r.line != nil {
+					r.pad(col)
+					r.space = false
+				}
+				r.words(cells)
+				break
+			}
+			r.join = true
+			r.raw(cells)
+			col += widths[k] + 2
+			r.pad(col)
+		}
+		r.join = false
+		r.flush()
+	}
+	r.indent, r.fill = indent, fill
+}
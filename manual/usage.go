@@ -0,0 +1,55 @@
+package manual
+
+import (
+	"context"
+	"os/exec"
+	"time"
+
+	"github.com/bryce/bashly/cmds"
+)
+
+// usageTimeout is how long a command is given to print its usage.
+const usageTimeout = 2 * time.Second
+
+// Usage provides the usage that commands print when run with --help. Only
+// commands found in PATH are run, and they are given no input.
+type Usage struct{}
+
+// Name returns the name of the provider.
+func (Usage) Name() string {
+	return "--help"
+}
+
+// Layout returns the layout of the options in usage output.
+func (Usage) Layout() Layout {
+	return UsageLayout
+}
+
+// Get returns the usage of a command and its subcommands.
+func (Usage) Get(ctx context.Context, command *cmds.Command, width int) (Page, error) {
+	if command.Section != "" || command.Kind == cmds.Reserved || isBuiltin(command) || !validCommand(command) {
+		return nil, ErrNoPage
+	}
+	path, err := exec.LookPath(command.Name)
+	if err != nil {
+		return nil, ErrNoPage
+	}
+
+	key := cacheKey{Provider: "usage", Name: command.FullName()}
+	if page, ok := pageCache.get(key, path); ok {
+		return page, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, usageTimeout)
+	defer cancel()
+	usage := exec.CommandContext(ctx, path, append(append([]string{}, command.Subcommands...), "--help")...)
+	// Many commands exit with an error after printing their usage
+	bytes, _ := usage.Output()
+	if len(bytes) == 0 {
+		return nil, ErrNoPage
+	}
+
+	page := Page(bytes)
+	pageCache.set(key, path, page)
+	return page, nil
+}